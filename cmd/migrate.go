@@ -0,0 +1,105 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <file|dir> ...",
+	Short: "Rewrite yip files in place, migrating them to the latest config version",
+	Long: `migrate upgrades the given yip files, and the yaml files in the given
+directories, to the latest version of the config format, and rewrites them.
+Files which are already up to date are left untouched.
+
+For example:
+
+	$> yip migrate --dry-run /oem
+	$> yip migrate /oem
+`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		files, err := yamlFiles(args)
+		if err != nil {
+			return err
+		}
+		var errs error
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			migrated, changed, err := schema.Migrate(b)
+			if err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("%s: %w", f, err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "# %s would be migrated to version %d\n%s", f, schema.CurrentVersion, migrated)
+				continue
+			}
+			if err := rewriteFile(f, migrated); err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Migrated %s to version %d\n", f, schema.CurrentVersion)
+		}
+		return errs
+	},
+}
+
+// rewriteFile replaces the content of the file keeping its mode. The content
+// is written to a temporary file first, so the file is never left half written.
+func rewriteFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
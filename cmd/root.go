@@ -15,11 +15,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/mudler/yip/pkg/console"
 	"github.com/mudler/yip/pkg/executor"
 	"github.com/mudler/yip/pkg/logger"
@@ -66,15 +72,35 @@ For example:
 
 	$> yip -s initramfs https://<yip.yaml> /path/to/disk <definition.yaml> ...
 	$> yip -s initramfs <yip.yaml> <yip2.yaml> ...
+	$> yip -s rootfs,initramfs,boot /oem
 	$> cat def.yaml | yip -
+	$> yip -s initramfs --dry-run <yip.yaml>
+	$> yip -s initramfs --report report.json <yip.yaml>
+	$> yip -s initramfs --strict <yip.yaml>
+	$> yip -s boot --metrics-file /var/lib/node_exporter/textfile/yip.prom /oem
+	$> yip -s initramfs --analyze --format dot <yip.yaml> | dot -Tsvg > graph.svg
 `,
+	// Sources are positional arguments, they must not be taken for unknown subcommands
+	Args: cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		stage, _ := cmd.Flags().GetString("stage")
-		dot, _ := cmd.Flags().GetBool("dotnotation")
+		stages, _ := cmd.Flags().GetStringSlice("stage")
 		analyze, _ := cmd.Flags().GetBool("analyze")
+		reportFile, _ := cmd.Flags().GetString("report")
+		metricsFile, _ := cmd.Flags().GetString("metrics-file")
+		format, _ := cmd.Flags().GetString("format")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
 
 		ll := initLogger()
-		runner := executor.NewExecutor(executor.WithLogger(ll))
+		// Dry runs apply nothing, they must not be counted as runs
+		if metricsFile != "" && dryRun {
+			ll.Warnf("Not writing metrics to %s on a dry run", metricsFile)
+			metricsFile = ""
+		}
+		report := &executor.RunReport{}
+		runner, err := newRunner(cmd, ll, report)
+		if err != nil {
+			return err
+		}
 		fromStdin := len(args) == 1 && args[0] == "-"
 
 		ll.Infof("yip version %s", cmd.Version)
@@ -83,10 +109,6 @@ For example:
 		}
 		stdConsole := console.NewStandardConsole(console.WithLogger(ll))
 
-		if dot {
-			runner.Modifier(schema.DotNotationModifier)
-		}
-
 		if fromStdin {
 			std, err := ioutil.ReadAll(os.Stdin)
 			if err != nil {
@@ -97,13 +119,130 @@ For example:
 		}
 
 		if analyze {
-			runner.Analyze(stage, vfs.OSFS, stdConsole, args...)
-			return nil
+			graphs := []executor.SourceGraph{}
+			for _, stage := range stages {
+				for _, source := range args {
+					g, err := runner.Graph(stage, vfs.OSFS, stdConsole, source)
+					if err != nil {
+						return err
+					}
+					if len(stages) > 1 {
+						source = fmt.Sprintf("%s (%s)", source, stage)
+					}
+					graphs = append(graphs, executor.SourceGraph{Source: source, Graph: g})
+				}
+			}
+			return executor.WriteGraph(os.Stdout, format, graphs...)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		err = runner.RunStages(ctx, stages, vfs.OSFS, stdConsole, args...)
+		if reportFile != "" {
+			if rerr := writeReport(reportFile, report); rerr != nil {
+				err = multierror.Append(err, rerr)
+			}
+		}
+		if metricsFile != "" {
+			if merr := writeMetrics(metricsFile, report); merr != nil {
+				err = multierror.Append(err, merr)
+			}
 		}
-		return runner.Run(stage, vfs.OSFS, stdConsole, args...)
+		return err
 	},
 }
 
+// newRunner returns the executor configured with the persistent flags, filling report
+func newRunner(cmd *cobra.Command, ll logger.Interface, report *executor.RunReport) (executor.Executor, error) {
+	dot, _ := cmd.Flags().GetBool("dotnotation")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	stateDir, _ := cmd.Flags().GetString("state-dir")
+	enabledPlugins, _ := cmd.Flags().GetStringSlice("enable-plugins")
+	disabledPlugins, _ := cmd.Flags().GetStringSlice("disable-plugins")
+	strict, _ := cmd.Flags().GetString("strict")
+
+	// Conditionals can't be enabled alone, only plugins are filtered by --enable-plugins
+	if err := checkPluginNames(enabledPlugins, executor.PluginNames()); err != nil {
+		return nil, err
+	}
+	if err := checkPluginNames(disabledPlugins, append(executor.PluginNames(), executor.ConditionalNames()...)); err != nil {
+		return nil, err
+	}
+	switch executor.StrictMode(strict) {
+	case executor.StrictOff, executor.StrictWarn, executor.StrictError:
+	default:
+		return nil, fmt.Errorf("unknown strict mode '%s', available modes: %s, %s", strict, executor.StrictWarn, executor.StrictError)
+	}
+
+	runner := executor.NewExecutor(
+		executor.WithLogger(ll),
+		executor.WithDryRun(dryRun),
+		executor.WithReport(report),
+		executor.WithStateDir(stateDir),
+		executor.WithEnabledPlugins(enabledPlugins...),
+		executor.WithoutPlugins(disabledPlugins...),
+		executor.WithStrict(executor.StrictMode(strict)),
+	)
+	if dot {
+		runner.Modifier(schema.DotNotationModifier)
+	}
+	return runner, nil
+}
+
+// checkPluginNames fails if any of the names is not among the known ones
+func checkPluginNames(names, known []string) error {
+	for _, n := range names {
+		found := false
+		for _, k := range known {
+			if n == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown plugin '%s', available plugins: %s", n, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func writeReport(path string, report *executor.RunReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return report.WriteJSON(f)
+}
+
+// writeMetrics writes the metrics of the report to a temporary file renamed to path,
+// so the node_exporter textfile collector never reads a partially written file.
+// The counters of the metrics already in path are carried over.
+func writeMetrics(path string, report *executor.RunReport) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var previous io.Reader
+	if prev, err := os.Open(path); err == nil {
+		defer prev.Close()
+		previous = prev
+	}
+	if err := report.WriteMetrics(f, previous); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -114,7 +253,16 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringP("stage", "s", "default", "Stage to apply")
+	rootCmd.PersistentFlags().StringSliceP("stage", "s", []string{"default"}, "Stages to apply, in order (e.g. rootfs,initramfs,boot)")
 	rootCmd.PersistentFlags().BoolP("analyze", "a", false, "Analize execution graph")
+	rootCmd.PersistentFlags().String("format", executor.GraphFormatText, "Format of the execution graph printed by --analyze (text, dot, mermaid, json)")
+	rootCmd.PersistentFlags().BoolP("dry-run", "n", false, "Report the changes that would be applied, without applying them")
+	rootCmd.PersistentFlags().String("report", "", "Write a JSON report of the run to the given file")
+	rootCmd.PersistentFlags().String("metrics-file", "", "Write Prometheus metrics of the run to the given file, for the node_exporter textfile collector")
+	rootCmd.PersistentFlags().String("state-dir", executor.DefaultStateDir, "Directory where the stages which run once or per instance are recorded")
+	rootCmd.PersistentFlags().StringSlice("enable-plugins", []string{}, "Enable only the given plugins (e.g. files,directories)")
+	rootCmd.PersistentFlags().StringSlice("disable-plugins", []string{}, "Disable the given plugins and conditionals (e.g. commands,packages)")
+	rootCmd.PersistentFlags().String("strict", "", "Fail ('error', the default when given without value) or warn (--strict=warn) on unknown fields in yip files")
+	rootCmd.PersistentFlags().Lookup("strict").NoOptDefVal = string(executor.StrictError)
 	rootCmd.PersistentFlags().BoolP("dotnotation", "d", false, "Parse input in dotnotation ( e.g. `stages.foo.name=..` ) ")
 }
@@ -0,0 +1,111 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <file|dir> ...",
+	Short: "Validate yip files against the yip JSON Schema",
+	Long: `validate checks the given yip files, and the yaml files in the given
+directories, reporting unknown fields, wrong types and invalid values
+with their file, line and column.
+
+For example:
+
+	$> yip validate /oem
+	$> yip validate --schema > yip.schema.json
+`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if printSchema, _ := cmd.Flags().GetBool("schema"); printSchema {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(schema.YipJSONSchema())
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("no files to validate")
+		}
+
+		files, err := yamlFiles(args)
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			errs, err := schema.Validate(b)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %s\n", f, err)
+				failed++
+				continue
+			}
+			for _, e := range errs {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", f, e)
+			}
+			if len(errs) > 0 {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d files are not valid", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// yamlFiles expands the directories to the yaml files they contain
+func yamlFiles(args []string) ([]string, error) {
+	res := []string{}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			res = append(res, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(path); !d.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				res = append(res, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func init() {
+	validateCmd.Flags().Bool("schema", false, "Print the JSON Schema of the yip files instead of validating them")
+	rootCmd.AddCommand(validateCmd)
+}
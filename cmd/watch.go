@@ -0,0 +1,122 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mudler/yip/pkg/console"
+	"github.com/mudler/yip/pkg/executor"
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/watch"
+	"github.com/spf13/cobra"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir> ...",
+	Short: "Apply the yip files in the given directories, and again whenever they change",
+	Long: `watch applies the yip files in the given directories, then watches them
+and applies again the files which are written or moved into them.
+
+For example:
+
+	$> yip watch -s boot /oem /usr/local/cloud-config
+	$> yip watch -s network --debounce 5s /oem
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stages, _ := cmd.Flags().GetStringSlice("stage")
+		debounce, _ := cmd.Flags().GetDuration("debounce")
+		reportFile, _ := cmd.Flags().GetString("report")
+		metricsFile, _ := cmd.Flags().GetString("metrics-file")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		for _, dir := range args {
+			info, err := os.Stat(dir)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+		}
+
+		ll := initLogger()
+		ll.Infof("yip version %s", rootCmd.Version)
+		if metricsFile != "" && dryRun {
+			ll.Warnf("Not writing metrics to %s on a dry run", metricsFile)
+			metricsFile = ""
+		}
+		stdConsole := console.NewStandardConsole(console.WithLogger(ll))
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		// Every run gets a new executor, so its report covers only that run
+		apply := func(sources []string) {
+			report := &executor.RunReport{}
+			runner, err := newRunner(cmd, ll, report)
+			if err != nil {
+				ll.Error(err.Error())
+				return
+			}
+			if err := runner.RunStages(ctx, stages, vfs.OSFS, stdConsole, sources...); err != nil {
+				ll.Errorf("Failed applying %v: %s", sources, err)
+			}
+			if reportFile != "" {
+				if err := writeReport(reportFile, report); err != nil {
+					ll.Errorf("Failed writing the report: %s", err)
+				}
+			}
+			if metricsFile != "" {
+				if err := writeMetrics(metricsFile, report); err != nil {
+					ll.Errorf("Failed writing the metrics: %s", err)
+				}
+			}
+		}
+
+		apply(args)
+		ll.Infof("Watching %v for changes", args)
+		return watch.Watch(ctx, debounce, func(files []string) {
+			if files = existingFiles(ll, files); len(files) > 0 {
+				apply(files)
+			}
+		}, args...)
+	},
+}
+
+// existingFiles filters out the files removed after being changed
+func existingFiles(ll logger.Interface, files []string) []string {
+	res := []string{}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			ll.Debugf("Skipping %s: %s", f, err)
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+func init() {
+	watchCmd.Flags().Duration("debounce", 2*time.Second, "Time to wait for further changes before applying the changed files")
+	rootCmd.AddCommand(watchCmd)
+}
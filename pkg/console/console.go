@@ -15,15 +15,23 @@
 package console
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
 	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/plugins"
 	"github.com/sirupsen/logrus"
 	"os/exec"
+	"time"
 )
 
+// waitDelay is how long to wait for the output of a killed command to be closed,
+// as processes spawned by it can keep it open
+const waitDelay = 5 * time.Second
+
 type StandardConsole struct {
 	logger logger.Interface
+	ctx    context.Context
 }
 
 type StandardConsoleOptions func(*StandardConsole) error
@@ -46,9 +54,25 @@ func NewStandardConsole(opts ...StandardConsoleOptions) *StandardConsole {
 
 }
 
+// WithContext returns a copy of the console which kills the commands it runs
+// when the context is cancelled
+func (s StandardConsole) WithContext(ctx context.Context) plugins.Console {
+	s.ctx = ctx
+	return &s
+}
+
+func (s StandardConsole) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
 func (s StandardConsole) Run(cmd string, opts ...func(cmd *exec.Cmd)) (string, error) {
 	s.logger.Debugf("running command `%s`", cmd)
-	c := exec.Command("sh", "-c", cmd)
+	c := exec.CommandContext(s.context(), "sh", "-c", cmd)
+	c.WaitDelay = waitDelay
+	killProcessGroup(c)
 	for _, o := range opts {
 		o(c)
 	}
@@ -66,7 +90,20 @@ func (s StandardConsole) Start(cmd *exec.Cmd, opts ...func(cmd *exec.Cmd)) error
 	for _, o := range opts {
 		o(cmd)
 	}
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.context().Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
 }
 
 func (s StandardConsole) RunTemplate(st []string, template string) error {
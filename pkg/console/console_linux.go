@@ -0,0 +1,17 @@
+//go:build linux
+
+package console
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs the command in its own process group, and kills the whole
+// group when the command is cancelled, so processes spawned by it don't outlive it.
+func killProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+}
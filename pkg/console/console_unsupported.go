@@ -0,0 +1,7 @@
+//go:build !linux
+
+package console
+
+import "os/exec"
+
+func killProcessGroup(c *exec.Cmd) {}
@@ -16,7 +16,7 @@ package executor
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sanity-io/litter"
 	"os"
@@ -35,34 +35,91 @@ import (
 // DefaultExecutor is the default yip Executor.
 // It simply creates file and executes command for a linux executor
 type DefaultExecutor struct {
-	plugins      []Plugin
-	conditionals []Plugin
+	plugins      []namedPlugin
+	conditionals []namedPlugin
 	modifier     schema.Modifier
 	logger       logger.Interface
+	dryRun       bool
+	report       *RunReport
+	stateDir     string
+	strict       StrictMode
+
+	enabledPlugins  []string
+	disabledPlugins []string
+	// disabledConditionals are the conditionals disabled by name, the stages using
+	// them are skipped
+	disabledConditionals []string
 }
 
 func (e *DefaultExecutor) Plugins(p []Plugin) {
-	e.plugins = p
+	e.plugins = toNamedPlugins(p)
 }
 
 func (e *DefaultExecutor) Conditionals(p []Plugin) {
-	e.conditionals = p
+	e.conditionals = toNamedPlugins(p)
 }
 
 func (e *DefaultExecutor) Modifier(m schema.Modifier) {
 	e.modifier = m
 }
 
+// enabledPlugin returns the plugin with the given name, if enabled
+func (e *DefaultExecutor) enabledPlugin(name string) (namedPlugin, bool) {
+	for _, p := range e.plugins {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return namedPlugin{}, false
+}
+
+// stageDump are the options to dump stages with, set once as ops can run concurrently
+var stageDump = func() litter.Options {
+	o := litter.Config
+	o.HideZeroValues = true
+	return o
+}()
+
+// abortError is returned when a stage with the abort failure policy fails
+type abortError struct {
+	error
+}
+
 type op struct {
 	fn      func(context.Context) error
 	deps    []string
 	after   []string
 	options []herd.OpOption
 	name    string
+	// parallel ops are not chained to the previous ops
+	parallel bool
+	// fatal ops abort the run when they fail
+	fatal bool
+	// background ops don't hold the following layers of the graph
+	background bool
 }
 
 type opList []*op
 
+// background runs in background the parallel ops nothing depends on, so they
+// don't hold the following layers of the graph. Ops with dependants can't be run
+// in background, as their dependants would run without waiting for them. Fatal ops
+// aren't run in background either, so their failure aborts the run right away.
+func (l opList) background() {
+	depended := map[string]bool{}
+	for _, o := range l {
+		for _, d := range append(o.after, o.deps...) {
+			depended[d] = true
+		}
+	}
+	for _, o := range l {
+		if o.parallel && !o.fatal && !depended[o.name] {
+			o.background = true
+			o.options = append(o.options, herd.Background)
+		}
+	}
+}
+
 func (l opList) uniqueNames() {
 	names := map[string]int{}
 
@@ -76,12 +133,27 @@ func (l opList) uniqueNames() {
 	}
 }
 
-func (e *DefaultExecutor) applyStage(config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console) error {
-	var errs error
-	for _, p := range e.conditionals {
-		if err := p(e.logger, stage, fs, console); err != nil {
+func (e *DefaultExecutor) applyStage(ctx context.Context, config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, statePath string, rep *OpReport) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stage '%s' not started: %w", stageName, err)
+	}
+	if applied(fs, statePath) {
+		e.logger.Infof("Skip '%s', already applied (frequency: %s)", stageName, stage.GetFrequency())
+		rep.skip("", fmt.Sprintf("already applied (frequency: %s)", stage.GetFrequency()))
+		return nil
+	}
+	for _, name := range e.disabledConditionals {
+		if usesConditional(stage, name) {
+			e.logger.Warnf("(conditional) Skip '%s', conditional '%s' is disabled", stageName, name)
+			rep.skip(name, fmt.Sprintf("%s: conditional is disabled", name))
+			return nil
+		}
+	}
+	for _, c := range e.conditionals {
+		if err := c.run(ctx, e.logger, stage, fs, plugins.ConsoleWithContext(ctx, console)); err != nil {
 			e.logger.Warnf("(conditional) Skip '%s' stage name: %s",
 				err.Error(), stageName)
+			rep.skip(c.name, fmt.Sprintf("%s: %s", c.name, err.Error()))
 			return nil
 		}
 	}
@@ -91,13 +163,115 @@ func (e *DefaultExecutor) applyStage(config schema.YipConfig, stageName string,
 		len(stage.Commands),
 		len(stage.Files))
 
-	litter.Config.HideZeroValues = true
-	e.logger.Debugf("Stage: %s", litter.Sdump(stage))
+	e.logger.Debugf("Stage: %s", stageDump.Sdump(stage))
+
+	if e.dryRun {
+		return e.planStage(config, stageName, stage, fs, console, rep)
+	}
+
+	var err error
+	if _, ok := e.enabledPlugin("commands"); !ok && stage.WaitFor.Command != "" {
+		err = fmt.Errorf("wait_for command can't be run, the commands plugin is disabled")
+	} else {
+		err = plugins.WaitFor(ctx, e.logger, stage, fs, console)
+	}
+	if err != nil {
+		err = fmt.Errorf("stage '%s' not started: %w", stageName, err)
+	} else {
+		err = e.runTransaction(ctx, config, stageName, stage, fs, console, rep)
+	}
+	if herr := e.runHooks(ctx, stageName, stage, fs, console, err); herr != nil {
+		err = multierror.Append(err, herr)
+	}
+	if err != nil {
+		if stage.OnFailure.Policy == schema.FailureIgnore {
+			e.logger.Warnf("Ignoring failure of stage '%s': %s", stageName, err)
+			return nil
+		}
+		return err
+	}
+	return markApplied(fs, statePath)
+}
+
+// runStageWithRetries runs the plugins of the stage, attempting it again on failure
+// as many times as the stage retries allow.
+func (e *DefaultExecutor) runStageWithRetries(ctx context.Context, config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, rep *OpReport) error {
+	var errs error
+	attempts := stage.Retries + 1
+	for attempt := 1; ; attempt++ {
+		errs = e.runStageAttempt(ctx, config, stageName, stage, fs, console, rep)
+		if errs == nil || attempt >= attempts || ctx.Err() != nil {
+			break
+		}
+		e.logger.Warnf("Stage '%s' failed (attempt %d/%d), retrying in %ds", stageName, attempt, attempts, stage.RetryDelay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(stage.RetryDelay) * time.Second):
+		}
+	}
+	return errs
+}
+
+// attemptGracePeriod is how long a cancelled attempt is waited for, so the plugins not
+// honouring the context don't overlap with the next attempt or the rollback.
+var attemptGracePeriod = 30 * time.Second
+
+// runStageAttempt runs the plugins of the stage once, bounded by the stage timeout.
+// The context of the plugins is cancelled when the attempt times out or the run is
+// interrupted, and the plugins are waited for up to attemptGracePeriod. Plugins
+// still running after it are left running in the background.
+func (e *DefaultExecutor) runStageAttempt(ctx context.Context, config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, rep *OpReport) error {
+	attempt := rep.attempt()
+	var cancel context.CancelFunc
+	if stage.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(stage.Timeout)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.runPlugins(ctx, config, stageName, stage, fs, console, rep, attempt)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(attemptGracePeriod):
+			e.logger.Warnf("Stage '%s' still running %s after being cancelled, leaving it in background", stageName, attemptGracePeriod)
+		}
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("stage '%s' timed out after %ds", stageName, stage.Timeout)
+	case ctx.Err() != nil:
+		err = fmt.Errorf("stage '%s' interrupted: %w", stageName, ctx.Err())
+	}
+	if err != nil && ctx.Err() != nil {
+		e.logger.Errorf("Error on file %s on stage %s: %s", config.Source, stage.Name, err)
+	}
+	return err
+}
 
+func (e *DefaultExecutor) runPlugins(ctx context.Context, config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, rep *OpReport, attempt int) error {
+	var errs error
+	console = plugins.ConsoleWithContext(ctx, console)
 	for _, p := range e.plugins {
-		ctx, cancel := context.WithCancel(context.Background())
-		go stillAlive(ctx, e.logger, 10*time.Second, fmt.Sprintf("Still running stage '%s'", stageName))
-		if err := p(e.logger, stage, fs, console); err != nil {
+		if ctx.Err() != nil {
+			break
+		}
+		aliveCtx, cancel := context.WithCancel(ctx)
+		go stillAlive(aliveCtx, e.logger, 10*time.Second, fmt.Sprintf("Still running stage '%s'", stageName))
+		start := time.Now()
+		err := p.run(ctx, e.logger, stage, fs, console)
+		rep.addPlugin(p.name, attempt, time.Since(start), err)
+		if err != nil {
 			e.logger.Errorf("Error on file %s on stage %s: %s", config.Source, stage.Name, err)
 			errs = multierror.Append(errs, err)
 		}
@@ -106,6 +280,45 @@ func (e *DefaultExecutor) applyStage(config schema.YipConfig, stageName string,
 	return errs
 }
 
+// planStage reports the changes the plugins would apply for the stage, without applying them
+func (e *DefaultExecutor) planStage(config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, rep *OpReport) error {
+	var errs error
+	changes := 0
+	for _, p := range e.plugins {
+		if p.planner == nil {
+			e.logger.Debugf("[plan] '%s' plugin '%s' can't be planned, skipping it", stageName, p.name)
+			continue
+		}
+		cc, err := p.planner(e.logger, stage, fs, console)
+		if err != nil {
+			e.logger.Errorf("Error on file %s on stage %s: %s", config.Source, stage.Name, err)
+			errs = multierror.Append(errs, err)
+		}
+		rep.addChanges(cc)
+		for _, c := range cc {
+			changes++
+			e.logger.Infof("[plan] '%s' %s", stageName, c.String())
+			if c.Diff != "" {
+				e.logger.Infof("[plan] '%s' diff %s:\n%s", stageName, c.Target, c.Diff)
+			}
+		}
+	}
+	hooks, err := e.planHooks(stage, fs, console)
+	if err != nil {
+		e.logger.Errorf("Error on file %s on stage %s: %s", config.Source, stage.Name, err)
+		errs = multierror.Append(errs, err)
+	}
+	rep.addChanges(hooks)
+	for _, c := range hooks {
+		changes++
+		e.logger.Infof("[plan] '%s' %s", stageName, c.String())
+	}
+	if changes == 0 {
+		e.logger.Infof("[plan] '%s' no changes", stageName)
+	}
+	return errs
+}
+
 func stillAlive(ctx context.Context, log logger.Interface, tick time.Duration, message string) {
 	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
@@ -162,31 +375,116 @@ func (e *DefaultExecutor) genOpFromSchema(file, stage string, config schema.YipC
 			fn: func(ctx context.Context) error {
 				e.logger.Debugf("Reading '%s'", file)
 				e.logger.Debugf("Executing stage '%s'", opName)
-				return e.applyStage(config, opName, stageLocal, fs, console)
+				rep := e.report.newOp(file, stage, opName)
+				start := time.Now()
+				statePath, err := e.statePath(fs, stage, opName, stageLocal)
+				if err == nil {
+					err = e.applyStage(ctx, config, opName, stageLocal, fs, console, statePath, rep)
+				}
+				rep.done(time.Since(start), err)
+				return err
 			},
 			name:    opName,
 			options: []herd.OpOption{herd.WeakDeps},
 		}
 
+		switch st.OnFailure.Policy {
+		case schema.FailureAbort:
+			o.fatal = true
+			o.options = append(o.options, herd.FatalOp)
+		case "", schema.FailureContinue, schema.FailureIgnore:
+		default:
+			e.logger.Warnf("Unknown on_failure policy '%s' for stage '%s', defaulting to '%s'", st.OnFailure.Policy, opName, schema.FailureContinue)
+		}
+
 		for _, d := range st.After {
 			o.after = append(o.after, d.Name)
 		}
 
-		if i != 0 && len(st.After) == 0 {
+		if st.Parallel {
+			o.parallel = true
+		} else if prev != "" && len(st.After) == 0 {
 			o.deps = append(o.deps, prev)
 		}
 
 		results = append(results, o)
 
-		prev = opName
+		if !st.Parallel {
+			prev = opName
+		}
 	}
 
 	return results
 }
 
-func (e *DefaultExecutor) dirOps(stage, dir string, fs vfs.FS, console plugins.Console) ([]*op, error) {
-	results := []*op{}
-	prev := []*op{}
+// yipFile is a yip config loaded from a source, with the file it was read from
+type yipFile struct {
+	path   string
+	config schema.YipConfig
+}
+
+// loadSource loads the yip configs of a source, which can be a file, a directory,
+// an url or the content of a yip config. The configs included by the loaded ones are
+// returned before them, and every config is returned only once per source.
+func (e *DefaultExecutor) loadSource(uri string, fs vfs.FS) ([]yipFile, error) {
+	f, err := fs.Stat(uri)
+	inc := schema.NewIncludes(fs, e.modifier, e.loadOptions()...)
+
+	switch {
+	case err == nil && f.IsDir():
+		return e.loadDir(uri, fs, inc)
+	case err == nil:
+		configs, err := inc.Load(uri, schema.FromFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading '%s': %w", uri, err)
+		}
+		return toYipFiles(uri, configs), nil
+	case utils.IsUrl(uri):
+		configs, err := inc.Load(uri, schema.FromUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading '%s': %w", uri, err)
+		}
+		return toYipFiles(uri, configs), nil
+	default:
+		configs, err := inc.Load(uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		return toYipFiles("<STDIN>", configs), nil
+	}
+}
+
+// toYipFiles returns the files of the configs loaded from path, which is the last of
+// them, the other ones being the configs it includes.
+func toYipFiles(path string, configs []*schema.YipConfig) []yipFile {
+	files := []yipFile{}
+	for i, c := range configs {
+		p := c.Source
+		if i == len(configs)-1 {
+			p = path
+		}
+		files = append(files, yipFile{p, *c})
+	}
+	return files
+}
+
+// loadOptions returns the options to load the configs with, according to the strict mode
+func (e *DefaultExecutor) loadOptions() []schema.LoadOption {
+	switch e.strict {
+	case StrictError:
+		return []schema.LoadOption{schema.Strict()}
+	case StrictWarn:
+		return []schema.LoadOption{schema.WarnUnknownFields(func(source, msg string) {
+			e.logger.Warnf("Unknown field in %s: %s", source, msg)
+		})}
+	default:
+		return nil
+	}
+}
+
+// loadDir loads the yaml files of a directory, in lexicographic order
+func (e *DefaultExecutor) loadDir(dir string, fs vfs.FS, inc *schema.Includes) ([]yipFile, error) {
+	results := []yipFile{}
 	err := vfs.Walk(fs, dir,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -204,43 +502,50 @@ func (e *DefaultExecutor) dirOps(stage, dir string, fs vfs.FS, console plugins.C
 				return nil
 			}
 
-			config, err := schema.Load(path, fs, schema.FromFile, e.modifier)
+			configs, err := inc.Load(path, schema.FromFile)
 			if err != nil {
-				return err
-
-			}
-			ops := e.genOpFromSchema(path, stage, *config, fs, console)
-			// mark lexicographic order dependency from previous blocks
-			if len(prev) > 0 && len(ops) > 0 {
-				for _, p := range prev {
-					if len(p.after) == 0 {
-						for _, o := range ops {
-							o.deps = append(o.deps, p.name)
-						}
-					}
-				}
+				return fmt.Errorf("failed loading '%s': %w", path, err)
 			}
-			prev = ops
-
-			// append results
-			results = append(results, ops...)
+			results = append(results, toYipFiles(path, configs)...)
 			return nil
 		})
 	return results, err
 }
 
-func writeDAG(dag [][]herd.GraphEntry) {
-	for i, layer := range dag {
-		fmt.Printf("%d.\n", (i + 1))
-		for _, op := range layer {
-			if op.Error != nil {
-				fmt.Printf(" <%s> (error: %s) (background: %t) (weak: %t)\n", op.Name, op.Error.Error(), op.Background, op.WeakDeps)
-			} else {
-				fmt.Printf(" <%s> (background: %t) (weak: %t)\n", op.Name, op.Background, op.WeakDeps)
+// filesOps generates the ops of the stage for the files of a source. The ops of
+// every file depend on the ops of the previous one.
+func (e *DefaultExecutor) filesOps(stage string, files []yipFile, fs vfs.FS, console plugins.Console) []*op {
+	results := []*op{}
+	prev := []*op{}
+	for _, f := range files {
+		ops := e.genOpFromSchema(f.path, stage, f.config, fs, console)
+		// mark lexicographic order dependency from previous blocks
+		if len(prev) > 0 && len(ops) > 0 {
+			for _, p := range prev {
+				if len(p.after) == 0 {
+					for _, o := range ops {
+						if !o.parallel {
+							o.deps = append(o.deps, p.name)
+						}
+					}
+				}
+			}
+		}
+		// parallel ops are neither chained to the previous blocks nor to the next ones
+		sequential := []*op{}
+		for _, o := range ops {
+			if !o.parallel {
+				sequential = append(sequential, o)
 			}
 		}
+		if len(sequential) > 0 || len(ops) == 0 {
+			prev = sequential
+		}
+
+		// append results
+		results = append(results, ops...)
 	}
-	return
+	return results
 }
 
 func (e *DefaultExecutor) Graph(stage string, fs vfs.FS, console plugins.Console, source string) ([][]herd.GraphEntry, error) {
@@ -273,61 +578,73 @@ func (e *DefaultExecutor) Analyze(stage string, fs vfs.FS, console plugins.Conso
 }
 
 func (e *DefaultExecutor) prepareDAG(stage, uri string, fs vfs.FS, console plugins.Console) (*herd.Graph, error) {
-	f, err := fs.Stat(uri)
+	files, err := e.loadSource(uri, fs)
+	if err != nil {
+		return nil, err
+	}
+	g, _ := e.buildDAG(stage, files, fs, console)
+	return g, nil
+}
 
-	g := herd.DAG(herd.EnableInit)
-	var ops opList
-	switch {
-	case err == nil && f.IsDir():
-		ops, err = e.dirOps(stage, uri, fs, console)
-		if err != nil {
-			return nil, err
-		}
-	case err == nil:
-		config, err := schema.Load(uri, fs, schema.FromFile, e.modifier)
-		if err != nil {
-			return nil, err
-		}
+// backgroundOps tracks the ops run in background, as herd doesn't wait for them
+// when the graph is aborted by a fatal op.
+type backgroundOps map[string]chan struct{}
 
-		ops = e.genOpFromSchema(uri, stage, *config, fs, console)
-	case utils.IsUrl(uri):
-		config, err := schema.Load(uri, fs, schema.FromUrl, e.modifier)
-		if err != nil {
-			return nil, err
-		}
+func (b backgroundOps) track(name string, fn func(context.Context) error) func(context.Context) error {
+	done := make(chan struct{})
+	b[name] = done
+	return func(ctx context.Context) error {
+		defer close(done)
+		return fn(ctx)
+	}
+}
 
-		ops = e.genOpFromSchema(uri, stage, *config, fs, console)
-	default:
-		config, err := schema.Load(uri, fs, nil, e.modifier)
-		if err != nil {
-			return nil, err
+// wait waits for the background ops started before the graph was aborted, which are
+// the ones in the layers up to the one of the failed fatal op.
+func (b backgroundOps) wait(g *herd.Graph) {
+	for _, layer := range g.Analyze() {
+		aborted := false
+		for _, op := range layer {
+			if done, ok := b[op.Name]; ok {
+				<-done
+			}
+			if op.Fatal && op.Error != nil {
+				aborted = true
+			}
+		}
+		if aborted {
+			return
 		}
-
-		ops = e.genOpFromSchema("<STDIN>", stage, *config, fs, console)
 	}
+}
+
+// buildDAG returns the graph of the ops of the stage for the files of a source
+func (e *DefaultExecutor) buildDAG(stage string, files []yipFile, fs vfs.FS, console plugins.Console) (*herd.Graph, backgroundOps) {
+	g := herd.DAG(herd.EnableInit, herd.CollectOrphans)
+	var ops opList = e.filesOps(stage, files, fs, console)
 
 	// Ensure all names are unique
 	ops.uniqueNames()
+	ops.background()
+	background := backgroundOps{}
 	for _, o := range ops {
-		g.Add(o.name, append(o.options, herd.WithCallback(o.fn), herd.WithDeps(append(o.after, o.deps...)...))...)
+		fn := o.fn
+		if o.background {
+			fn = background.track(o.name, fn)
+		}
+		g.Add(o.name, append(o.options, herd.WithCallback(fn), herd.WithDeps(append(o.after, o.deps...)...))...)
 	}
 
-	return g, nil
+	return g, background
 }
 
-func (e *DefaultExecutor) runStage(stage, uri string, fs vfs.FS, console plugins.Console) (err error) {
-	g, err := e.prepareDAG(stage, uri, fs, console)
-	if err != nil {
-		return err
-	}
-
-	if g == nil {
-		return fmt.Errorf("no dag could be created")
-	}
+func (e *DefaultExecutor) runStage(ctx context.Context, stage string, files []yipFile, fs vfs.FS, console plugins.Console) (err error) {
+	g, background := e.buildDAG(stage, files, fs, console)
 
-	err = g.Run(context.Background())
+	err = g.Run(ctx)
 	if err != nil {
-		return err
+		background.wait(g)
+		return &abortError{err}
 	}
 
 	for _, g := range g.Analyze() {
@@ -343,18 +660,90 @@ func (e *DefaultExecutor) runStage(stage, uri string, fs vfs.FS, console plugins
 
 // Run takes a list of URI to run yipfiles from. URI can be also a dir or a local path, as well as a remote
 func (e *DefaultExecutor) Run(stage string, fs vfs.FS, console plugins.Console, args ...string) error {
+	return e.RunContext(context.Background(), stage, fs, console, args...)
+}
+
+// RunContext is Run, interrupting the execution when the context is cancelled.
+// Plugins and commands being executed are cancelled, and the remaining stages are not started.
+func (e *DefaultExecutor) RunContext(ctx context.Context, stage string, fs vfs.FS, console plugins.Console, args ...string) error {
+	return e.RunStages(ctx, []string{stage}, fs, console, args...)
+}
+
+// RunStages is RunContext for a list of stages, which are run in order.
+// Sources are loaded only once, before running the first stage, so files added
+// to them by a stage are not picked up by the following ones.
+func (e *DefaultExecutor) RunStages(ctx context.Context, stages []string, fs vfs.FS, console plugins.Console, args ...string) error {
 	var errs error
-	e.logger.Infof("Running stage: %s\n", stage)
+	defer e.report.finish()
+	ctx, rebootRequested := plugins.DeferReboot(ctx)
+
+	sources := [][]yipFile{}
 	for _, source := range args {
-		if err := e.runStage(stage, source, fs, console); err != nil {
+		files, err := e.loadSource(source, fs)
+		if err != nil {
+			e.report.addError(err)
 			errs = multierror.Append(errs, err)
+			continue
+		}
+		sources = append(sources, files)
+	}
+
+	var current string
+STAGES:
+	for _, stage := range stages {
+		current = stage
+		e.logger.Infof("Running stage: %s\n", stage)
+		e.report.begin(stage)
+		for _, files := range sources {
+			if ctx.Err() != nil {
+				break STAGES
+			}
+			if err := e.runStage(ctx, stage, files, fs, console); err != nil {
+				errs = multierror.Append(errs, err)
+				if errors.As(err, new(*abortError)) {
+					e.logger.Errorf("Aborting stage '%s': %s", stage, err)
+					break STAGES
+				}
+			}
 		}
+		e.logger.Infof("Done executing stage '%s'\n", stage)
+	}
+	// Interrupted runs are failed runs, also when the interruption left no op failing
+	if err := ctx.Err(); err != nil {
+		err = fmt.Errorf("stage '%s' interrupted: %w", current, err)
+		e.logger.Errorf("Interrupting stage '%s': %s", current, ctx.Err())
+		e.report.addError(err)
+		errs = multierror.Append(errs, err)
+	}
+	if err := e.reboot(ctx, rebootRequested, console); err != nil {
+		errs = multierror.Append(errs, err)
 	}
-	e.logger.Infof("Done executing stage '%s'\n", stage)
 	return errs
 }
 
+// reboot reboots the system if a plugin required it during the run, which is done
+// at its end so the following steps and stages aren't cut short. Interrupted runs
+// don't reboot.
+func (e *DefaultExecutor) reboot(ctx context.Context, requested func() bool, console plugins.Console) error {
+	if !requested() {
+		return nil
+	}
+	if ctx.Err() != nil {
+		e.logger.Warnf("Not rebooting as required, the run was interrupted")
+		return nil
+	}
+	e.logger.Infof("Rebooting, as required during the run")
+	out, err := console.Run("reboot")
+	if err != nil {
+		e.logger.Debugf("Command output: %s", out)
+		err = fmt.Errorf("failed rebooting: %w", err)
+		e.report.addError(err)
+	}
+	return err
+}
+
 // Apply applies a yip Config file by creating files and running commands defined.
+// The steps of the stage are ordered and run as the ones of the files given to Run.
 func (e *DefaultExecutor) Apply(stageName string, s schema.YipConfig, fs vfs.FS, console plugins.Console) error {
 	currentStages := s.Stages[stageName]
 	if len(currentStages) == 0 {
@@ -363,32 +752,13 @@ func (e *DefaultExecutor) Apply(stageName string, s schema.YipConfig, fs vfs.FS,
 	}
 
 	e.logger.Infof("Applying '%s' for stage '%s'. Total stages: %d\n", s.Name, stageName, len(currentStages))
+	e.report.begin(stageName)
+	defer e.report.finish()
 
-	var errs error
-STAGES:
-	for _, stage := range currentStages {
-		for _, p := range e.conditionals {
-			if err := p(e.logger, stage, fs, console); err != nil {
-				e.logger.Warnf("Error '%s' in stage name: %s stage: %s\n",
-					err.Error(), s.Name, stageName)
-				continue STAGES
-			}
-		}
-		e.logger.Infof(
-			"Processing stage step '%s'. ( commands: %d, files: %d, ... )\n",
-			stageName,
-			len(stage.Commands),
-			len(stage.Files))
-
-		b, _ := json.Marshal(stage)
-		e.logger.Debugf("Stage: %s", string(b))
-
-		for _, p := range e.plugins {
-			if err := p(e.logger, stage, fs, console); err != nil {
-				e.logger.Error(err.Error())
-				errs = multierror.Append(errs, err)
-			}
-		}
+	ctx, rebootRequested := plugins.DeferReboot(context.Background())
+	errs := e.runStage(ctx, stageName, []yipFile{{s.Source, s}}, fs, console)
+	if err := e.reboot(ctx, rebootRequested, console); err != nil {
+		errs = multierror.Append(errs, err)
 	}
 
 	e.logger.Infof(
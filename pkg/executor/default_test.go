@@ -16,20 +16,27 @@ package executor_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/sanity-io/litter"
+	"github.com/spectrocloud-labs/herd"
 	"github.com/twpayne/go-vfs/v5"
 
 	"github.com/mudler/yip/pkg/console"
 	"github.com/sirupsen/logrus"
 
 	. "github.com/mudler/yip/pkg/executor"
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/plugins"
 	"github.com/mudler/yip/pkg/schema"
 	consoletests "github.com/mudler/yip/tests/console"
 	"github.com/twpayne/go-vfs/v5/vfst"
@@ -161,6 +168,45 @@ var _ = Describe("Executor", func() {
 
 		})
 
+		It("Applies configs honouring dependencies", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs.TempDir()
+			defer cleanup()
+
+			config := schema.YipConfig{Name: "cfg", Stages: map[string][]schema.Stage{
+				"foo": {
+					{
+						// Parallel stages don't hold the following ones, which unblock it
+						Name:     "slow",
+						Parallel: true,
+						Timeout:  10,
+						Commands: []string{"while [ ! -e " + temp + "/fast ]; do sleep 0.01; done", "echo slow >> " + temp + "/order"},
+					},
+					{
+						Name:     "fast",
+						Commands: []string{"echo fast >> " + temp + "/order", "touch " + temp + "/fast"},
+					},
+					{
+						Name:     "last",
+						After:    []schema.Dependency{{Name: "cfg.slow"}, {Name: "cfg.fast"}},
+						Commands: []string{"echo last >> " + temp + "/order"},
+					},
+					{
+						Name:     "failing",
+						Commands: []string{"exit 1"},
+					},
+				},
+			}}
+
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			b, err := os.ReadFile(temp + "/order")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("fast\nslow\nlast\n"))
+		})
+
 		It("Run yip files in sequence", func() {
 			testConsole := console.NewStandardConsole()
 
@@ -569,6 +615,168 @@ stages:
 			Expect(len(g)).To(Equal(5), fmt.Sprintf("%#v\n", g))
 		})
 
+		It("does not chain parallel stages", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+    initramfs:
+    - name: "first"
+      commands:
+      - echo first
+    - name: "download"
+      parallel: true
+      commands:
+      - echo download
+    - name: "second"
+      commands:
+      - echo second
+`,
+				"/some/yip/02_second.yaml": `
+stages:
+    initramfs:
+    - name: "clone"
+      parallel: true
+      commands:
+      - echo clone
+    - name: "third"
+      commands:
+      - echo third
+    - name: "after clone"
+      after:
+      - name: "/some/yip/02_second.yaml.clone"
+      commands:
+      - echo after
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			g, err := def.Graph("initramfs", fs, testConsole, "/some/yip")
+			Expect(err).Should(BeNil())
+
+			entries := map[string]herd.GraphEntry{}
+			for _, layer := range g {
+				for _, e := range layer {
+					entries[e.Name] = e
+				}
+			}
+			Expect(entries["/some/yip/01_first.yaml.download"].Dependencies).To(BeEmpty())
+			Expect(entries["/some/yip/01_first.yaml.download"].Background).To(BeTrue())
+			Expect(entries["/some/yip/01_first.yaml.second"].Dependencies).To(ConsistOf("/some/yip/01_first.yaml.first"))
+			Expect(entries["/some/yip/02_second.yaml.third"].Dependencies).To(ContainElement("/some/yip/01_first.yaml.second"))
+			Expect(entries["/some/yip/02_second.yaml.third"].Dependencies).ToNot(ContainElement("/some/yip/01_first.yaml.download"))
+			// clone has a dependant, so it can't run in background
+			Expect(entries["/some/yip/02_second.yaml.clone"].Dependencies).To(BeEmpty())
+			Expect(entries["/some/yip/02_second.yaml.clone"].Background).To(BeFalse())
+		})
+
+		It("runs parallel stages concurrently", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: "wait"
+    parallel: true
+    timeout: 10
+    commands:
+    - while [ ! -e ` + temp + `/ready ]; do sleep 0.1; done
+    - touch ` + temp + `/done
+  - name: "ready"
+    commands:
+    - touch ` + temp + `/ready
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).To(Succeed())
+			// Background ops are collected before returning
+			_, err = os.Stat(temp + "/done")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("applies included configs once per source", func() {
+			testConsole := consoletests.TestConsole{}
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/00_base.yaml": `
+name: base
+stages:
+  test:
+  - name: setup
+    commands:
+    - echo base
+`,
+				"/some/yip/10_web.yaml": `
+name: web
+include:
+- 00_base.yaml
+stages:
+  test:
+  - name: web
+    after:
+    - name: base.setup
+    commands:
+    - echo web
+`,
+				"/some/yip/20_db.yaml": `
+include:
+- 00_base.yaml
+stages:
+  test:
+  - name: db
+    after:
+    - name: web.web
+    commands:
+    - echo db
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo base", "echo web", "echo db"}))
+		})
+
+		It("waits for parallel stages when the run is aborted", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: "slow"
+    parallel: true
+    commands:
+    - sleep 1 && touch ` + temp + `/done
+  - name: "fail"
+    on_failure: abort
+    commands:
+    - exit 1
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).ToNot(Succeed())
+			_, err = os.Stat(temp + "/done")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
 		It("has multiple instructions", func() {
 			testConsole := console.NewStandardConsole()
 
@@ -724,6 +932,840 @@ stages:
 
 			Expect(string(b)).Should(Equal("rootfs.before\nsecond.rootfs.before\nrootfs\n2\nsecond.rootfs\nsecond.2\ninitramfs\nsecond.initramfs\n"), string(b))
 		})
+		It("does not apply changes in dry-run mode", func() {
+			buf := bytes.Buffer{}
+			l := logrus.New()
+			l.SetOutput(&buf)
+			def := NewExecutor(WithLogger(l), WithDryRun(true))
+			testConsole := consoletests.TestConsole{}
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/tmp/test/bar": "boo\n",
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: "write"
+    files:
+    - path: /tmp/test/bar
+      content: "baz\n"
+      permissions: 0644
+    commands:
+    - echo foo
+    finally:
+    - echo done
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, &testConsole, "/some/yip")
+			Expect(err).Should(BeNil())
+			Expect(testConsole.Commands).To(BeEmpty())
+
+			b, err := fs.ReadFile("/tmp/test/bar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("boo\n"))
+
+			Expect(buf.String()).To(ContainSubstring("files: update /tmp/test/bar"))
+			Expect(buf.String()).To(ContainSubstring("-boo"))
+			Expect(buf.String()).To(ContainSubstring("+baz"))
+			Expect(buf.String()).To(ContainSubstring("commands: run echo foo"))
+			Expect(buf.String()).To(ContainSubstring("commands: run echo done (finally)"))
+		})
+
+		It("retries failed stages", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			temp := fs.TempDir()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands: []string{"echo -n x >> " + temp + "/attempts && [ \"$(cat " + temp + "/attempts)\" = xxx ]"},
+					Retries:  2,
+				}},
+			}}
+
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			b, err := os.ReadFile(temp + "/attempts")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("xxx"))
+
+			config.Stages["foo"][0].Retries = 1
+			Expect(os.Remove(temp + "/attempts")).To(Succeed())
+			err = def.Run("foo", fs, testConsole, config.ToString())
+			Expect(err).Should(HaveOccurred())
+			b, err = os.ReadFile(temp + "/attempts")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("xx"))
+		})
+
+		It("aborts stages exceeding their timeout", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands: []string{"sleep 10"},
+					Timeout:  1,
+				}},
+			}}
+
+			start := time.Now()
+			err = def.Run("foo", fs, testConsole, config.ToString())
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("timed out after 1s"))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("kills the commands of stages exceeding their timeout", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands: []string{"sleep 2 && touch " + temp + "/done"},
+					Timeout:  1,
+				}},
+			}}
+
+			Expect(def.Run("foo", fs, testConsole, config.ToString())).ToNot(Succeed())
+			time.Sleep(2 * time.Second)
+			_, err = os.Stat(temp + "/done")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("waits for the plugins of a timed out attempt before retrying", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			var running, overlapping int32
+			e := NewExecutor(WithLogger(l))
+			e.Plugins([]Plugin{func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) error {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.StoreInt32(&overlapping, 1)
+				}
+				defer atomic.AddInt32(&running, -1)
+				time.Sleep(1500 * time.Millisecond)
+				return nil
+			}})
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Timeout: 1, Retries: 1}},
+			}}
+
+			Expect(e.Run("foo", fs, &testConsole, config.ToString())).ToNot(Succeed())
+			Expect(atomic.LoadInt32(&overlapping)).To(Equal(int32(0)))
+			Expect(atomic.LoadInt32(&running)).To(Equal(int32(0)))
+		})
+
+		It("interrupts the run when the context is cancelled", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "first", Commands: []string{"sleep 10"}},
+					{Name: "second", Commands: []string{"touch " + temp + "/second"}},
+				},
+			}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				cancel()
+			}()
+
+			start := time.Now()
+			err = def.RunContext(ctx, "foo", fs, testConsole, config.ToString())
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("interrupted"))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			_, err = os.Stat(temp + "/second")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("runs the stage hooks", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: failing
+    commands:
+    - exit 1
+    on_success:
+    - touch ` + temp + `/failing_success
+    on_failure:
+    - 'echo "$YIP_STAGE_NAME: $YIP_STAGE_ERROR" > ` + temp + `/failing_failure'
+    finally:
+    - touch ` + temp + `/failing_finally
+  - name: succeeding
+    commands:
+    - "true"
+    on_success:
+    - echo -n "$YIP_STAGE_ERROR" > ` + temp + `/succeeding_success
+    on_failure:
+    - touch ` + temp + `/succeeding_failure
+    finally:
+    - touch ` + temp + `/succeeding_finally
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).ToNot(Succeed())
+
+			for _, f := range []string{"failing_failure", "failing_finally", "succeeding_success", "succeeding_finally"} {
+				_, err = os.Stat(filepath.Join(temp, f))
+				Expect(err).ShouldNot(HaveOccurred(), f)
+			}
+			for _, f := range []string{"failing_success", "succeeding_failure"} {
+				_, err = os.Stat(filepath.Join(temp, f))
+				Expect(err).Should(HaveOccurred(), f)
+			}
+
+			b, err := os.ReadFile(filepath.Join(temp, "failing_failure"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(HavePrefix("/some/yip/01_first.yaml.failing: "))
+			Expect(string(b)).To(ContainSubstring("exit 1"))
+			b, err = os.ReadFile(filepath.Join(temp, "succeeding_success"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(BeEmpty())
+		})
+
+		It("stops the run when a stage with the abort policy fails", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - on_failure: abort
+    commands:
+    - exit 1
+`,
+				"/some/yip/02_second.yaml": `
+stages:
+  test:
+  - commands:
+    - touch ` + temp + `/second
+`,
+				"/other/yip/01_first.yaml": `
+stages:
+  test:
+  - commands:
+    - touch ` + temp + `/other
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, testConsole, "/some/yip", "/other/yip")
+			Expect(err).Should(HaveOccurred())
+			_, err = os.Stat(temp + "/second")
+			Expect(err).Should(HaveOccurred())
+			_, err = os.Stat(temp + "/other")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("runs multiple stages in order", func() {
+			testConsole := console.NewStandardConsole()
+			report := &RunReport{}
+			def := NewExecutor(WithLogger(l), WithReport(report))
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  initramfs:
+  - commands:
+    - echo initramfs >> ` + temp + `/order
+  boot:
+  - commands:
+    - echo boot >> ` + temp + `/order
+`,
+				"/other/yip/01_first.yaml": `
+stages:
+  rootfs:
+  - commands:
+    - echo rootfs >> ` + temp + `/order
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.RunStages(context.Background(), []string{"rootfs", "initramfs", "boot"}, fs, testConsole, "/some/yip", "/other/yip")).To(Succeed())
+
+			b, err := os.ReadFile(temp + "/order")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("rootfs\ninitramfs\nboot\n"))
+			Expect(report.Stages).To(Equal([]string{"rootfs", "initramfs", "boot"}))
+		})
+
+		It("does not run the following stages after an abort", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  initramfs:
+  - on_failure: abort
+    commands:
+    - exit 1
+  boot:
+  - commands:
+    - touch ` + temp + `/boot
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.RunStages(context.Background(), []string{"initramfs", "boot"}, fs, testConsole, "/some/yip")).ToNot(Succeed())
+			_, err = os.Stat(temp + "/boot")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("does not run stages whose wait_for conditions are not met", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: waiting
+    wait_for:
+      file: /dev/missing
+      timeout: 1
+    commands:
+    - touch ` + temp + `/waiting
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, testConsole, "/some/yip")
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("timed out after 1s waiting for file /dev/missing"))
+			_, err = os.Stat(temp + "/waiting")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("does not report failures of stages with the ignore policy", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - on_failure: ignore
+    commands:
+    - exit 1
+  - commands:
+    - touch ` + temp + `/second
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, testConsole, "/some/yip")
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = os.Stat(temp + "/second")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("applies run_once stages only once", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithStateDir("/state"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: once
+    run_once: true
+    commands:
+    - echo once
+  - name: always
+    commands:
+    - echo always
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(testConsole.Commands).To(ConsistOf("echo once", "echo always", "echo always"))
+
+			entries, err := fs.ReadDir("/state/once/test")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		It("reboots at the end of the run when required by the packages", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/etc/os-release":          "ID=debian\nVERSION=10\n",
+				"/var/run/reboot-required": "*** System restart required ***\n",
+				"/some/yip/01_first.yaml": `
+stages:
+  network:
+  - name: packages
+    packages:
+      install: [foo]
+      reboot_if_required: true
+  - name: after
+    commands:
+    - echo after
+  boot:
+  - commands:
+    - echo boot
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.RunStages(context.Background(), []string{"network", "boot"}, fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"apt-get -y --no-install-recommends install foo", "echo after", "echo boot", "reboot"}))
+		})
+
+		It("applies per-instance stages once for every instance id", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithStateDir("/state"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/run/config/instance_id": "i-1",
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - frequency: per-instance
+    commands:
+    - echo instance
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo instance"}))
+
+			Expect(fs.WriteFile("/run/config/instance_id", []byte("i-2\n"), 0644)).To(Succeed())
+			Expect(def.Run("test", fs, &testConsole, "/some/yip")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo instance", "echo instance"}))
+
+			_, err = fs.Stat("/state/instances/i-2/test")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("applies run_once stages again until they succeed", func() {
+			testConsole := console.NewStandardConsole()
+			def := NewExecutor(WithLogger(l), WithStateDir("/state"))
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - run_once: true
+    commands:
+    - echo run >> ` + temp + `/runs
+    - test -e ` + temp + `/ok
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).ToNot(Succeed())
+			Expect(os.WriteFile(temp+"/ok", []byte{}, 0644)).To(Succeed())
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).To(Succeed())
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).To(Succeed())
+
+			b, err := os.ReadFile(temp + "/runs")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("run\nrun\n"))
+		})
+
+		It("disables plugins by name", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithoutPlugins("commands", "if"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{
+						Commands: []string{"echo foo"},
+						Files:    []schema.File{{Path: "/tmp/foo", Content: "foo", Permissions: 0644}},
+					},
+					{
+						If:    "true",
+						Files: []schema.File{{Path: "/tmp/bar", Content: "bar", Permissions: 0644}},
+					},
+				},
+			}}
+			Expect(def.Apply("foo", config, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).To(BeEmpty())
+			b, err := fs.ReadFile("/tmp/foo")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("foo"))
+			// Steps using a disabled conditional are skipped
+			_, err = fs.Stat("/tmp/bar")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("skips the stage hooks when the commands plugin is disabled", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithoutPlugins("commands"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands:  []string{"echo foo"},
+					OnSuccess: []string{"echo success"},
+					Finally:   []string{"echo finally"},
+				}},
+			}}
+			Expect(def.Apply("foo", config, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).To(BeEmpty())
+		})
+
+		It("doesn't run wait_for commands when the commands plugin is disabled", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithoutPlugins("commands"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					WaitFor: schema.WaitFor{Command: "true"},
+					Files:   []schema.File{{Path: "/tmp/foo", Content: "foo", Permissions: 0644}},
+				}},
+			}}
+			err = def.Apply("foo", config, fs, &testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("commands plugin is disabled"))
+			Expect(testConsole.Commands).To(BeEmpty())
+			_, err = fs.Stat("/tmp/foo")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("enables only the given plugins", func() {
+			testConsole := consoletests.TestConsole{}
+			def := NewExecutor(WithLogger(l), WithEnabledPlugins("commands"))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands: []string{"echo foo"},
+					Files:    []schema.File{{Path: "/tmp/foo", Content: "foo", Permissions: 0644}},
+				}},
+			}}
+			Expect(def.Apply("foo", config, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo foo"}))
+			_, err = fs.Stat("/tmp/foo")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("fails loading files with unknown fields in strict mode", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_typo.yaml": `
+stages:
+  foo:
+  - authorised_keys:
+      root: [github:mudler]
+    commands: [echo typo]
+`,
+				"/some/yip/02_valid.yaml": `
+stages:
+  foo:
+  - commands: [echo valid]
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			testConsole := consoletests.TestConsole{}
+			Expect(NewExecutor(WithLogger(l)).Run("foo", fs, &testConsole, "/some/yip/01_typo.yaml")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo typo"}))
+
+			testConsole = consoletests.TestConsole{}
+			err = NewExecutor(WithLogger(l), WithStrict(StrictError)).Run("foo", fs, &testConsole, "/some/yip/01_typo.yaml", "/some/yip/02_valid.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed loading '/some/yip/01_typo.yaml'"))
+			Expect(err.Error()).To(ContainSubstring("line 4: field authorised_keys not found"))
+			Expect(testConsole.Commands).To(Equal([]string{"echo valid"}))
+		})
+
+		It("warns about unknown fields in strict warn mode", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_typo.yaml": `
+stages:
+  foo:
+  - authorised_keys:
+      root: [github:mudler]
+    commands: [echo typo]
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			buf := &bytes.Buffer{}
+			warnLogger := logrus.New()
+			warnLogger.SetOutput(buf)
+
+			testConsole := consoletests.TestConsole{}
+			Expect(NewExecutor(WithLogger(warnLogger), WithStrict(StrictWarn)).Run("foo", fs, &testConsole, "/some/yip/01_typo.yaml")).To(Succeed())
+			Expect(testConsole.Commands).To(Equal([]string{"echo typo"}))
+			Expect(buf.String()).To(ContainSubstring("Unknown field in /some/yip/01_typo.yaml: line 4: field authorised_keys not found"))
+		})
+
+		It("registers plugins by name", func() {
+			DeferCleanup(SaveRegistry())
+			called := 0
+			Expect(RegisterPlugin("test_counter", func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) error {
+				called++
+				return nil
+			}, nil)).To(Succeed())
+			Expect(RegisterPlugin("commands", nil, nil)).ToNot(Succeed())
+			Expect(PluginNames()).To(ContainElements("files", "commands", "layout", "datasource", "test_counter"))
+			Expect(ConditionalNames()).To(ContainElements("if", "only_os", "if_files"))
+
+			testConsole := consoletests.TestConsole{}
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{"foo": {{}}}}
+			Expect(NewExecutor(WithLogger(l)).Apply("foo", config, fs, &testConsole)).To(Succeed())
+			Expect(called).To(Equal(1))
+			Expect(NewExecutor(WithLogger(l), WithoutPlugins("test_counter")).Apply("foo", config, fs, &testConsole)).To(Succeed())
+			Expect(called).To(Equal(1))
+		})
+
+		It("fills the run report", func() {
+			testConsole := console.NewStandardConsole()
+			report := &RunReport{}
+			def := NewExecutor(WithLogger(l), WithReport(report))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: skipped
+    if: "false"
+    commands:
+    - echo foo
+  - name: failing
+    retries: 1
+    commands:
+    - exit 1
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, testConsole, "/some/yip")
+			Expect(err).Should(HaveOccurred())
+
+			Expect(report.Stages).To(Equal([]string{"test"}))
+			Expect(report.End).ToNot(BeTemporally("<", report.Start))
+			Expect(report.StageDurations).To(HaveKey("test"))
+			Expect(report.Ops).To(HaveLen(2))
+
+			ops := map[string]*OpReport{}
+			for _, o := range report.Ops {
+				Expect(o.Source).To(Equal("/some/yip/01_first.yaml"))
+				Expect(o.Stage).To(Equal("test"))
+				ops[o.Name] = o
+			}
+			Expect(ops).To(HaveKey(ContainSubstring("skipped")))
+			Expect(ops).To(HaveKey(ContainSubstring("failing")))
+			for name, o := range ops {
+				if strings.Contains(name, "skipped") {
+					Expect(o.Skipped).To(BeTrue())
+					Expect(o.SkipReason).To(HavePrefix("if: "))
+					Expect(o.Conditional).To(Equal("if"))
+					Expect(o.Plugins).To(BeEmpty())
+				} else {
+					Expect(o.Skipped).To(BeFalse())
+					Expect(o.Attempts).To(Equal(2))
+					Expect(o.Error).ToNot(BeEmpty())
+					Expect(o.Plugins).To(ContainElement(And(
+						HaveField("Name", "commands"),
+						HaveField("Attempt", 2),
+						HaveField("Error", Not(BeEmpty())),
+					)))
+				}
+			}
+
+			buf := bytes.Buffer{}
+			Expect(report.WriteJSON(&buf)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`"skip_reason"`))
+		})
+
+		It("reports interrupted runs as failed", func() {
+			testConsole := console.NewStandardConsole()
+			report := &RunReport{}
+			def := NewExecutor(WithLogger(l), WithReport(report))
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.YipConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Name: "never", Commands: []string{"true"}}},
+			}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			Expect(def.RunContext(ctx, "foo", fs, testConsole, config.ToString())).ToNot(Succeed())
+			Expect(report.Ops).To(BeEmpty())
+			Expect(report.Errors).To(ConsistOf(ContainSubstring("stage 'foo' interrupted")))
+
+			buf := bytes.Buffer{}
+			Expect(report.WriteMetrics(&buf, nil)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`yip_runs_total{stages="foo",result="failure"} 1`))
+		})
+
+		It("restores the files of failed transactional stages", func() {
+			testConsole := console.NewStandardConsole()
+			report := &RunReport{}
+			def := NewExecutor(WithLogger(l), WithReport(report))
+
+			dns, cleanupDNS, err := vfst.NewTestFS(map[string]interface{}{"/resolv.conf": "nameserver 1.1.1.1\n"})
+			Expect(err).Should(BeNil())
+			defer cleanupDNS()
+			resolvConf, err := dns.RawPath("/resolv.conf")
+			Expect(err).Should(BeNil())
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/etc/existing": &vfst.File{Contents: []byte("old"), Perm: 0600},
+				"/some/yip/01_first.yaml": `
+stages:
+  test:
+  - name: kept
+    transactional: true
+    files:
+    - path: /etc/kept
+      content: kept
+      permissions: 0644
+  - name: failing
+    transactional: true
+    dns:
+      path: ` + resolvConf + `
+      nameservers:
+      - 8.8.8.8
+    files:
+    - path: /etc/existing
+      content: new
+      permissions: 0644
+    - path: /etc/created
+      content: created
+      permissions: 0644
+    commands:
+    - exit 1
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			Expect(def.Run("test", fs, testConsole, "/some/yip")).ToNot(Succeed())
+
+			b, err := fs.ReadFile("/etc/kept")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("kept"))
+
+			b, err = fs.ReadFile("/etc/existing")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("old"))
+			info, err := fs.Stat("/etc/existing")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+			_, err = fs.Stat("/etc/created")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			b, err = dns.ReadFile("/resolv.conf")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("nameserver 1.1.1.1\n"))
+
+			Expect(report.Ops).To(ContainElement(And(
+				HaveField("Name", ContainSubstring("failing")),
+				HaveField("RolledBack", ConsistOf("/etc/existing", "/etc/created", resolvConf)),
+			)))
+		})
+
 		It("same instructions in different cloud-config files", func() {
 			buf := bytes.Buffer{}
 			l := logrus.New()
@@ -15,6 +15,8 @@
 package executor
 
 import (
+	"context"
+
 	"github.com/mudler/yip/pkg/logger"
 	"github.com/mudler/yip/pkg/plugins"
 	"github.com/sirupsen/logrus"
@@ -28,6 +30,8 @@ import (
 type Executor interface {
 	Apply(string, schema.YipConfig, vfs.FS, plugins.Console) error
 	Run(string, vfs.FS, plugins.Console, ...string) error
+	RunContext(context.Context, string, vfs.FS, plugins.Console, ...string) error
+	RunStages(context.Context, []string, vfs.FS, plugins.Console, ...string) error
 	Plugins([]Plugin)
 	Conditionals([]Plugin)
 	Modifier(m schema.Modifier)
@@ -37,6 +41,14 @@ type Executor interface {
 
 type Plugin func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) error
 
+// ContextPlugin is a Plugin receiving a context, which is cancelled when the run is
+// interrupted or the stage times out.
+type ContextPlugin func(context.Context, logger.Interface, schema.Stage, vfs.FS, plugins.Console) error
+
+// Planner is the dry-run counterpart of a Plugin: it reports the changes the plugin
+// would apply for a stage without mutating the system.
+type Planner func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) ([]plugins.Change, error)
+
 type Options func(d *DefaultExecutor) error
 
 // WithLogger sets the logger for the cloudrunner
@@ -50,7 +62,7 @@ func WithLogger(i logger.Interface) Options {
 // WithPlugins sets the plugins for the cloudrunner
 func WithPlugins(p ...Plugin) Options {
 	return func(d *DefaultExecutor) error {
-		d.plugins = p
+		d.plugins = toNamedPlugins(p)
 		return nil
 	}
 }
@@ -58,52 +70,95 @@ func WithPlugins(p ...Plugin) Options {
 // WithConditionals sets the conditionals for the cloudrunner
 func WithConditionals(p ...Plugin) Options {
 	return func(d *DefaultExecutor) error {
-		d.conditionals = p
+		d.conditionals = toNamedPlugins(p)
+		return nil
+	}
+}
+
+// WithDryRun enables the dry-run mode: stages are planned instead of being applied,
+// and the changes each plugin would apply are reported.
+func WithDryRun(b bool) Options {
+	return func(d *DefaultExecutor) error {
+		d.dryRun = b
+		return nil
+	}
+}
+
+// WithReport sets the report which is filled with the details of the executed ops
+func WithReport(r *RunReport) Options {
+	return func(d *DefaultExecutor) error {
+		d.report = r
+		return nil
+	}
+}
+
+// WithStateDir sets the directory where the stages with a frequency other than always
+// are recorded once applied. Defaults to DefaultStateDir.
+func WithStateDir(dir string) Options {
+	return func(d *DefaultExecutor) error {
+		d.stateDir = dir
+		return nil
+	}
+}
+
+// WithEnabledPlugins enables only the plugins with the given names, as listed by PluginNames.
+// Conditionals are not affected.
+func WithEnabledPlugins(names ...string) Options {
+	return func(d *DefaultExecutor) error {
+		d.enabledPlugins = append(d.enabledPlugins, names...)
+		return nil
+	}
+}
+
+// WithoutPlugins disables the plugins and the conditionals with the given names, as listed
+// by PluginNames and ConditionalNames. Steps using a disabled conditional are skipped.
+func WithoutPlugins(names ...string) Options {
+	return func(d *DefaultExecutor) error {
+		d.disabledPlugins = append(d.disabledPlugins, names...)
+		return nil
+	}
+}
+
+// StrictMode defines how unknown fields in yip configs are handled
+type StrictMode string
+
+const (
+	// StrictOff ignores unknown fields, this is the default
+	StrictOff StrictMode = ""
+	// StrictWarn logs a warning for every unknown field
+	StrictWarn StrictMode = "warn"
+	// StrictError fails loading configs with unknown fields
+	StrictError StrictMode = "error"
+)
+
+// WithStrict sets how unknown fields in the loaded yip configs are handled
+func WithStrict(mode StrictMode) Options {
+	return func(d *DefaultExecutor) error {
+		d.strict = mode
 		return nil
 	}
 }
 
 // NewExecutor returns an executor from the stringified version of it.
+// Plugins and conditionals are the ones registered with RegisterPlugin and RegisterConditional.
 func NewExecutor(opts ...Options) Executor {
 	d := &DefaultExecutor{
-		logger: logrus.New(),
-		conditionals: []Plugin{
-			plugins.NodeConditional,
-			plugins.IfConditional,
-			plugins.OnlyIfOS,
-			plugins.OnlyIfOSVersion,
-			plugins.IfArch,
-			plugins.IfServiceManager,
-			plugins.IfFiles,
-		},
-		plugins: []Plugin{
-			plugins.DNS,
-			plugins.Download,
-			plugins.Git,
-			plugins.Entities,
-			plugins.EnsureDirectories,
-			plugins.EnsureFiles,
-			plugins.Commands,
-			plugins.DeleteEntities,
-			plugins.Hostname,
-			plugins.Sysctl,
-			plugins.User,
-			plugins.SSH,
-			plugins.LoadModules,
-			plugins.Timesyncd,
-			plugins.Systemctl,
-			plugins.Environment,
-			plugins.SystemdFirstboot,
-			plugins.DataSources,
-			plugins.Layout,
-			plugins.PackagePins,
-			plugins.Packages,
-			plugins.UnpackImage,
-		},
+		logger:       logrus.New(),
+		stateDir:     DefaultStateDir,
+		conditionals: registeredConditionals(),
+		plugins:      registeredPlugins(),
 	}
 
 	for _, o := range opts {
 		o(d)
 	}
+
+	d.plugins = filterPlugins(d.plugins, d.enabledPlugins, d.disabledPlugins)
+	for _, c := range d.conditionals {
+		if contains(d.disabledPlugins, c.name) {
+			d.disabledConditionals = append(d.disabledConditionals, c.name)
+		}
+	}
+	d.conditionals = filterPlugins(d.conditionals, nil, d.disabledPlugins)
 	return d
 }
@@ -0,0 +1,26 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+// SaveRegistry saves the registered plugins and conditionals, returning a function
+// restoring them, so tests registering plugins don't leak them to the other ones.
+func SaveRegistry() func() {
+	plugins, conditionals := registeredPlugins(), registeredConditionals()
+	return func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		pluginRegistry, conditionalRegistry = plugins, conditionals
+	}
+}
@@ -0,0 +1,256 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spectrocloud-labs/herd"
+)
+
+// Supported formats for WriteGraph
+const (
+	GraphFormatText    = "text"
+	GraphFormatDot     = "dot"
+	GraphFormatMermaid = "mermaid"
+	GraphFormatJSON    = "json"
+)
+
+// SourceGraph is the execution graph of the ops of a source, as returned by Graph
+type SourceGraph struct {
+	Source string
+	Graph  [][]herd.GraphEntry
+}
+
+// WriteGraph renders the execution graphs in the given format
+func WriteGraph(w io.Writer, format string, graphs ...SourceGraph) error {
+	switch format {
+	case GraphFormatText, "":
+		return writeGraphText(w, graphs)
+	case GraphFormatDot:
+		return writeGraphDot(w, graphs)
+	case GraphFormatMermaid:
+		return writeGraphMermaid(w, graphs)
+	case GraphFormatJSON:
+		return writeGraphJSON(w, graphs)
+	default:
+		return fmt.Errorf("unknown graph format '%s'", format)
+	}
+}
+
+// graphOps returns the ops of the graph, skipping the init op added by herd.
+// Ops are sorted by name in each layer, so the output is stable.
+func graphOps(g [][]herd.GraphEntry) [][]herd.GraphEntry {
+	res := [][]herd.GraphEntry{}
+	for _, layer := range g {
+		ops := []herd.GraphEntry{}
+		for _, op := range layer {
+			if op.Name == "init" && !op.WithCallback {
+				continue
+			}
+			deps := []string{}
+			for _, d := range op.Dependencies {
+				if d != "init" {
+					deps = append(deps, d)
+				}
+			}
+			op.Dependencies = deps
+			ops = append(ops, op)
+		}
+		sort.Slice(ops, func(i, j int) bool { return ops[i].Name < ops[j].Name })
+		if len(ops) > 0 {
+			res = append(res, ops)
+		}
+	}
+	return res
+}
+
+func isWeakDep(op herd.GraphEntry, dep string) bool {
+	if op.WeakDeps {
+		return true
+	}
+	for _, d := range op.WeakDependencies {
+		if d == dep {
+			return true
+		}
+	}
+	return false
+}
+
+func writeGraphText(w io.Writer, graphs []SourceGraph) error {
+	for _, sg := range graphs {
+		fmt.Fprintf(w, "%s:\n", sg.Source)
+		for i, layer := range sg.Graph {
+			fmt.Fprintf(w, "%d.\n", i+1)
+			for _, op := range layer {
+				if op.Error != nil {
+					fmt.Fprintf(w, " <%s> (error: %s) (background: %t) (weak: %t)\n", op.Name, op.Error.Error(), op.Background, op.WeakDeps)
+				} else {
+					fmt.Fprintf(w, " <%s> (background: %t) (weak: %t)\n", op.Name, op.Background, op.WeakDeps)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// nodeIDs assigns stable identifiers to the ops, as op names aren't valid identifiers
+type nodeIDs map[string]string
+
+func (n nodeIDs) id(name string) string {
+	if id, ok := n[name]; ok {
+		return id
+	}
+	n[name] = fmt.Sprintf("op%d", len(n))
+	return n[name]
+}
+
+func writeGraphDot(w io.Writer, graphs []SourceGraph) error {
+	ids := nodeIDs{}
+	var edges []string
+	fmt.Fprintln(w, "digraph yip {")
+	fmt.Fprintln(w, "  rankdir=TB;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for i, sg := range graphs {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(w, "    label=%q;\n", sg.Source)
+		for _, layer := range graphOps(sg.Graph) {
+			for _, op := range layer {
+				attrs := []string{fmt.Sprintf("label=%q", dotLabel(op))}
+				if op.Background {
+					attrs = append(attrs, "style=rounded")
+				}
+				if op.Fatal {
+					attrs = append(attrs, "penwidth=2")
+				}
+				if op.Error != nil {
+					attrs = append(attrs, "color=red")
+				}
+				fmt.Fprintf(w, "    %s [%s];\n", ids.id(sg.Source+"\x00"+op.Name), strings.Join(attrs, ", "))
+				for _, d := range op.Dependencies {
+					edge := fmt.Sprintf("  %s -> %s", ids.id(sg.Source+"\x00"+d), ids.id(sg.Source+"\x00"+op.Name))
+					if isWeakDep(op, d) {
+						edge += " [style=dashed]"
+					}
+					edges = append(edges, edge+";")
+				}
+			}
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, e := range edges {
+		fmt.Fprintln(w, e)
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+func dotLabel(op herd.GraphEntry) string {
+	if op.Error != nil {
+		return fmt.Sprintf("%s\nerror: %s", op.Name, op.Error.Error())
+	}
+	return op.Name
+}
+
+func writeGraphMermaid(w io.Writer, graphs []SourceGraph) error {
+	ids := nodeIDs{}
+	var edges, failed []string
+	fmt.Fprintln(w, "flowchart TD")
+	for i, sg := range graphs {
+		fmt.Fprintf(w, "  subgraph source%d[%q]\n", i, sg.Source)
+		for _, layer := range graphOps(sg.Graph) {
+			for _, op := range layer {
+				id := ids.id(sg.Source + "\x00" + op.Name)
+				label := op.Name
+				if op.Error != nil {
+					label += "<br/>error: " + op.Error.Error()
+					failed = append(failed, id)
+				}
+				label = strings.ReplaceAll(label, `"`, "#quot;")
+				if op.Background {
+					fmt.Fprintf(w, "    %s([\"%s\"])\n", id, label)
+				} else {
+					fmt.Fprintf(w, "    %s[\"%s\"]\n", id, label)
+				}
+				for _, d := range op.Dependencies {
+					arrow := "-->"
+					if isWeakDep(op, d) {
+						arrow = "-.->"
+					}
+					edges = append(edges, fmt.Sprintf("  %s %s %s", ids.id(sg.Source+"\x00"+d), arrow, id))
+				}
+			}
+		}
+		fmt.Fprintln(w, "  end")
+	}
+	for _, e := range edges {
+		fmt.Fprintln(w, e)
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(w, "  classDef failed stroke:#f00")
+		fmt.Fprintf(w, "  class %s failed\n", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+type jsonGraph struct {
+	Source string       `json:"source"`
+	Layers [][]jsonNode `json:"layers"`
+}
+
+type jsonNode struct {
+	Name             string   `json:"name"`
+	Dependencies     []string `json:"dependencies,omitempty"`
+	WeakDependencies []string `json:"weak_dependencies,omitempty"`
+	WeakDeps         bool     `json:"weak"`
+	Background       bool     `json:"background"`
+	Fatal            bool     `json:"fatal"`
+	Executed         bool     `json:"executed"`
+	Error            string   `json:"error,omitempty"`
+}
+
+func writeGraphJSON(w io.Writer, graphs []SourceGraph) error {
+	res := []jsonGraph{}
+	for _, sg := range graphs {
+		jg := jsonGraph{Source: sg.Source, Layers: [][]jsonNode{}}
+		for _, layer := range graphOps(sg.Graph) {
+			nodes := []jsonNode{}
+			for _, op := range layer {
+				n := jsonNode{
+					Name:             op.Name,
+					Dependencies:     op.Dependencies,
+					WeakDependencies: op.WeakDependencies,
+					WeakDeps:         op.WeakDeps,
+					Background:       op.Background,
+					Fatal:            op.Fatal,
+					Executed:         op.Executed,
+				}
+				if op.Error != nil {
+					n.Error = op.Error.Error()
+				}
+				nodes = append(nodes, n)
+			}
+			jg.Layers = append(jg.Layers, nodes)
+		}
+		res = append(res, jg)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
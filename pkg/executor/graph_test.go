@@ -0,0 +1,106 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	. "github.com/mudler/yip/pkg/executor"
+	"github.com/spectrocloud-labs/herd"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteGraph", func() {
+	graph := SourceGraph{
+		Source: "/oem",
+		Graph: [][]herd.GraphEntry{
+			{{Name: "init"}},
+			{{Name: "/oem/01.yaml.b", WithCallback: true, WeakDeps: true, Background: true}, {Name: "/oem/01.yaml.a", WithCallback: true, Dependencies: []string{"init"}}},
+			{{Name: "/oem/02.yaml.c", WithCallback: true, Fatal: true, Dependencies: []string{"/oem/01.yaml.a"}, WeakDependencies: []string{"/oem/01.yaml.a"}, Error: errors.New("failed")}},
+		},
+	}
+
+	It("renders text", func() {
+		buf := bytes.Buffer{}
+		Expect(WriteGraph(&buf, GraphFormatText, graph)).To(Succeed())
+		Expect(buf.String()).To(Equal(`/oem:
+1.
+ <init> (background: false) (weak: false)
+2.
+ </oem/01.yaml.b> (background: true) (weak: true)
+ </oem/01.yaml.a> (background: false) (weak: false)
+3.
+ </oem/02.yaml.c> (error: failed) (background: false) (weak: false)
+`))
+	})
+
+	It("renders dot", func() {
+		buf := bytes.Buffer{}
+		Expect(WriteGraph(&buf, GraphFormatDot, graph)).To(Succeed())
+		Expect(buf.String()).To(Equal(`digraph yip {
+  rankdir=TB;
+  node [shape=box];
+  subgraph cluster_0 {
+    label="/oem";
+    op0 [label="/oem/01.yaml.a"];
+    op1 [label="/oem/01.yaml.b", style=rounded];
+    op2 [label="/oem/02.yaml.c\nerror: failed", penwidth=2, color=red];
+  }
+  op0 -> op2 [style=dashed];
+}
+`))
+	})
+
+	It("renders mermaid", func() {
+		buf := bytes.Buffer{}
+		Expect(WriteGraph(&buf, GraphFormatMermaid, graph)).To(Succeed())
+		Expect(buf.String()).To(Equal(`flowchart TD
+  subgraph source0["/oem"]
+    op0["/oem/01.yaml.a"]
+    op1(["/oem/01.yaml.b"])
+    op2["/oem/02.yaml.c<br/>error: failed"]
+  end
+  op0 -.-> op2
+  classDef failed stroke:#f00
+  class op2 failed
+`))
+	})
+
+	It("renders json", func() {
+		buf := bytes.Buffer{}
+		Expect(WriteGraph(&buf, GraphFormatJSON, graph)).To(Succeed())
+
+		res := []map[string]interface{}{}
+		Expect(json.Unmarshal(buf.Bytes(), &res)).To(Succeed())
+		Expect(res).To(HaveLen(1))
+		Expect(res[0]["source"]).To(Equal("/oem"))
+		layers := res[0]["layers"].([]interface{})
+		Expect(layers).To(HaveLen(2))
+		Expect(layers[1]).To(ConsistOf(And(
+			HaveKeyWithValue("name", "/oem/02.yaml.c"),
+			HaveKeyWithValue("error", "failed"),
+			HaveKeyWithValue("fatal", true),
+			HaveKeyWithValue("dependencies", ConsistOf("/oem/01.yaml.a")),
+		)))
+	})
+
+	It("fails on unknown formats", func() {
+		Expect(WriteGraph(&bytes.Buffer{}, "svg", graph)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,107 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+const (
+	// StageNameEnv is the environment variable holding the name of the stage in its hooks
+	StageNameEnv = "YIP_STAGE_NAME"
+	// StageErrorEnv is the environment variable holding the error of the stage in its hooks,
+	// empty if the stage succeeded
+	StageErrorEnv = "YIP_STAGE_ERROR"
+)
+
+// envConsole is a console adding environment variables to the commands it runs
+type envConsole struct {
+	plugins.Console
+	env []string
+}
+
+func (c envConsole) Run(cmd string, opts ...func(*exec.Cmd)) (string, error) {
+	setEnv := func(cmd *exec.Cmd) {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	return c.Console.Run(cmd, append([]func(*exec.Cmd){setEnv}, opts...)...)
+}
+
+// runHooks runs the on_success or on_failure commands of the stage depending on its
+// error, and then the finally ones. Hooks are run by the commands plugin, and are
+// skipped when it is disabled.
+func (e *DefaultExecutor) runHooks(ctx context.Context, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, stageErr error) error {
+	hooks := stage.OnSuccess
+	errText := ""
+	if stageErr != nil {
+		hooks = stage.OnFailure.Commands
+		errText = stageErr.Error()
+	}
+	hooks = append(append([]string{}, hooks...), stage.Finally...)
+	if len(hooks) == 0 {
+		return nil
+	}
+	p, ok := e.enabledPlugin("commands")
+	if !ok {
+		e.logger.Warnf("Skipping the hooks of stage '%s', the commands plugin is disabled", stageName)
+		return nil
+	}
+
+	e.logger.Debugf("Running %d hooks of stage '%s'", len(hooks), stageName)
+	c := envConsole{
+		Console: plugins.ConsoleWithContext(ctx, console),
+		env:     []string{StageNameEnv + "=" + stageName, StageErrorEnv + "=" + errText},
+	}
+	if err := p.run(ctx, e.logger, schema.Stage{Commands: hooks}, fs, c); err != nil {
+		e.logger.Errorf("Error running the hooks of stage '%s': %s", stageName, err)
+		return fmt.Errorf("hooks of stage '%s' failed: %w", stageName, err)
+	}
+	return nil
+}
+
+// planHooks reports the commands the hooks of the stage would run, as the stage
+// outcome isn't known in dry-run mode all of them are reported.
+func (e *DefaultExecutor) planHooks(stage schema.Stage, fs vfs.FS, console plugins.Console) ([]plugins.Change, error) {
+	p, ok := e.enabledPlugin("commands")
+	if !ok || p.planner == nil {
+		return nil, nil
+	}
+	var changes []plugins.Change
+	for _, h := range []struct {
+		name     string
+		commands []string
+	}{
+		{"on_success", stage.OnSuccess},
+		{"on_failure", stage.OnFailure.Commands},
+		{"finally", stage.Finally},
+	} {
+		cc, err := p.planner(e.logger, schema.Stage{Commands: h.commands}, fs, console)
+		if err != nil {
+			return changes, err
+		}
+		for _, c := range cc {
+			c.Details = h.name
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,158 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Results of the ops in the yip_stage_ops metric
+const (
+	opResultSuccess        = "success"
+	opResultFailure        = "failure"
+	opResultSkipped        = "skipped"
+	opResultAlreadyApplied = "already_applied"
+)
+
+var opResults = []string{opResultSuccess, opResultFailure, opResultSkipped, opResultAlreadyApplied}
+
+// Results of the runs in the yip_runs_total metric
+const (
+	runResultSuccess = "success"
+	runResultFailure = "failure"
+)
+
+// WriteMetrics writes the report as Prometheus metrics in the text exposition format,
+// as read by the node_exporter textfile collector. Every series is labelled with the
+// stages of the run, so the metrics of runs of different stages can be written to
+// different files of the same directory. The yip_runs_total counters continue the ones
+// read from previous, the metrics written by the previous run, which can be nil.
+func (r *RunReport) WriteMetrics(w io.Writer, previous io.Reader) error {
+	r.Lock()
+	defer r.Unlock()
+
+	runStages := escapeLabel(strings.Join(r.Stages, ","))
+
+	stages := []string{}
+	ops := map[string]map[string]int{}
+	success := len(r.Errors) == 0
+	for _, s := range r.Stages {
+		if _, ok := ops[s]; !ok {
+			stages = append(stages, s)
+			ops[s] = map[string]int{}
+		}
+	}
+	for _, o := range r.Ops {
+		if _, ok := ops[o.Stage]; !ok {
+			stages = append(stages, o.Stage)
+			ops[o.Stage] = map[string]int{}
+		}
+		result := opResultSuccess
+		switch {
+		case o.Skipped && o.Conditional != "":
+			result = opResultSkipped
+		case o.Skipped:
+			result = opResultAlreadyApplied
+		case o.Error != "":
+			result = opResultFailure
+			success = false
+		}
+		ops[o.Stage][result]++
+	}
+
+	runs, err := readRuns(previous, runStages)
+	if err != nil {
+		return err
+	}
+	if success {
+		runs[runResultSuccess]++
+	} else {
+		runs[runResultFailure]++
+	}
+
+	b := bufio.NewWriter(w)
+	metric(b, "yip_runs_total", "counter", "Runs of yip, by result.")
+	for _, res := range []string{runResultSuccess, runResultFailure} {
+		fmt.Fprintf(b, "%s %d\n", runsSeries(runStages, res), runs[res])
+	}
+
+	metric(b, "yip_last_run_timestamp_seconds", "gauge", "Time the last yip run ended, in seconds since the epoch.")
+	fmt.Fprintf(b, "yip_last_run_timestamp_seconds{stages=\"%s\"} %f\n", runStages, float64(r.End.UnixNano())/1e9)
+
+	metric(b, "yip_last_run_duration_seconds", "gauge", "Duration of the last yip run.")
+	fmt.Fprintf(b, "yip_last_run_duration_seconds{stages=\"%s\"} %f\n", runStages, r.End.Sub(r.Start).Seconds())
+
+	metric(b, "yip_last_run_success", "gauge", "Whether the last yip run succeeded (1) or failed (0).")
+	if success {
+		fmt.Fprintf(b, "yip_last_run_success{stages=\"%s\"} 1\n", runStages)
+	} else {
+		fmt.Fprintf(b, "yip_last_run_success{stages=\"%s\"} 0\n", runStages)
+	}
+
+	metric(b, "yip_stage_duration_seconds", "gauge", "Duration of the stages of the last yip run.")
+	for _, s := range stages {
+		fmt.Fprintf(b, "yip_stage_duration_seconds{stages=\"%s\",stage=\"%s\"} %f\n", runStages, escapeLabel(s), r.StageDurations[s])
+	}
+
+	metric(b, "yip_stage_ops", "gauge", "Steps of the stages of the last yip run, by result.")
+	for _, s := range stages {
+		for _, res := range opResults {
+			fmt.Fprintf(b, "yip_stage_ops{stages=\"%s\",stage=\"%s\",result=\"%s\"} %d\n", runStages, escapeLabel(s), res, ops[s][res])
+		}
+	}
+
+	return b.Flush()
+}
+
+func runsSeries(stages, result string) string {
+	return fmt.Sprintf("yip_runs_total{stages=\"%s\",result=\"%s\"}", stages, result)
+}
+
+// readRuns reads the yip_runs_total counters of the given stages from the metrics of a
+// previous run. Missing counters start from zero.
+func readRuns(previous io.Reader, stages string) (map[string]int, error) {
+	runs := map[string]int{}
+	if previous == nil {
+		return runs, nil
+	}
+	scanner := bufio.NewScanner(previous)
+	for scanner.Scan() {
+		for _, res := range []string{runResultSuccess, runResultFailure} {
+			value, ok := strings.CutPrefix(scanner.Text(), runsSeries(stages, res)+" ")
+			if !ok {
+				continue
+			}
+			if n, err := strconv.Atoi(value); err == nil {
+				runs[res] = n
+			}
+		}
+	}
+	return runs, scanner.Err()
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,89 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/mudler/yip/pkg/executor"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteMetrics", func() {
+	It("writes the metrics of the run", func() {
+		start := time.Unix(1700000000, 0)
+		report := &RunReport{
+			Stages: []string{"initramfs", "boot"},
+			Start:  start,
+			End:    start.Add(3 * time.Second),
+			Ops: []*OpReport{
+				{Stage: "initramfs", Name: "a"},
+				{Stage: "initramfs", Name: "b", Skipped: true, Conditional: "if"},
+				{Stage: "boot", Name: "c", Error: "failed"},
+				{Stage: "boot", Name: "d", Skipped: true},
+			},
+			StageDurations: map[string]float64{"initramfs": 1, "boot": 2},
+		}
+
+		buf := bytes.Buffer{}
+		Expect(report.WriteMetrics(&buf, nil)).To(Succeed())
+		Expect(buf.String()).To(Equal(`# HELP yip_runs_total Runs of yip, by result.
+# TYPE yip_runs_total counter
+yip_runs_total{stages="initramfs,boot",result="success"} 0
+yip_runs_total{stages="initramfs,boot",result="failure"} 1
+# HELP yip_last_run_timestamp_seconds Time the last yip run ended, in seconds since the epoch.
+# TYPE yip_last_run_timestamp_seconds gauge
+yip_last_run_timestamp_seconds{stages="initramfs,boot"} 1700000003.000000
+# HELP yip_last_run_duration_seconds Duration of the last yip run.
+# TYPE yip_last_run_duration_seconds gauge
+yip_last_run_duration_seconds{stages="initramfs,boot"} 3.000000
+# HELP yip_last_run_success Whether the last yip run succeeded (1) or failed (0).
+# TYPE yip_last_run_success gauge
+yip_last_run_success{stages="initramfs,boot"} 0
+# HELP yip_stage_duration_seconds Duration of the stages of the last yip run.
+# TYPE yip_stage_duration_seconds gauge
+yip_stage_duration_seconds{stages="initramfs,boot",stage="initramfs"} 1.000000
+yip_stage_duration_seconds{stages="initramfs,boot",stage="boot"} 2.000000
+# HELP yip_stage_ops Steps of the stages of the last yip run, by result.
+# TYPE yip_stage_ops gauge
+yip_stage_ops{stages="initramfs,boot",stage="initramfs",result="success"} 1
+yip_stage_ops{stages="initramfs,boot",stage="initramfs",result="failure"} 0
+yip_stage_ops{stages="initramfs,boot",stage="initramfs",result="skipped"} 1
+yip_stage_ops{stages="initramfs,boot",stage="initramfs",result="already_applied"} 0
+yip_stage_ops{stages="initramfs,boot",stage="boot",result="success"} 0
+yip_stage_ops{stages="initramfs,boot",stage="boot",result="failure"} 1
+yip_stage_ops{stages="initramfs,boot",stage="boot",result="skipped"} 0
+yip_stage_ops{stages="initramfs,boot",stage="boot",result="already_applied"} 1
+`))
+	})
+
+	It("carries over the run counters of the previous metrics of the same stages", func() {
+		report := &RunReport{Stages: []string{"boot"}}
+		previous := strings.NewReader(`yip_runs_total{stages="boot",result="success"} 4
+yip_runs_total{stages="boot",result="failure"} 2
+yip_runs_total{stages="network",result="success"} 7
+`)
+
+		buf := bytes.Buffer{}
+		Expect(report.WriteMetrics(&buf, previous)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring(`yip_runs_total{stages="boot",result="success"} 5
+yip_runs_total{stages="boot",result="failure"} 2
+`))
+	})
+})
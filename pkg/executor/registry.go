@@ -0,0 +1,241 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+// namedPlugin is a plugin (or a conditional) with the name it's registered with,
+// its context aware variant and its planner for the dry-run mode, if any.
+type namedPlugin struct {
+	name      string
+	plugin    Plugin
+	ctxPlugin ContextPlugin
+	planner   Planner
+}
+
+func (p namedPlugin) run(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+	if p.ctxPlugin != nil {
+		return p.ctxPlugin(ctx, l, s, fs, console)
+	}
+	return p.plugin(l, s, fs, console)
+}
+
+var (
+	registryLock sync.Mutex
+
+	// pluginRegistry holds the plugins of the executors created with NewExecutor,
+	// in the order they are applied to every stage.
+	pluginRegistry = []namedPlugin{
+		{"dns", plugins.DNS, nil, plugins.PlanDNS},
+		{"downloads", plugins.Download, plugins.DownloadContext, plugins.PlanDownload},
+		{"git", plugins.Git, nil, plugins.PlanGit},
+		{"ensure_entities", plugins.Entities, nil, plugins.PlanEntities},
+		{"directories", plugins.EnsureDirectories, nil, plugins.PlanEnsureDirectories},
+		{"files", plugins.EnsureFiles, nil, plugins.PlanEnsureFiles},
+		{"commands", plugins.Commands, nil, plugins.PlanCommands},
+		{"delete_entities", plugins.DeleteEntities, nil, plugins.PlanDeleteEntities},
+		{"hostname", plugins.Hostname, nil, plugins.PlanHostname},
+		{"sysctl", plugins.Sysctl, nil, plugins.PlanSysctl},
+		{"users", plugins.User, nil, plugins.PlanUser},
+		{"authorized_keys", plugins.SSH, plugins.SSHContext, plugins.PlanSSH},
+		{"modules", plugins.LoadModules, nil, plugins.PlanLoadModules},
+		{"timesyncd", plugins.Timesyncd, nil, plugins.PlanTimesyncd},
+		{"systemctl", plugins.Systemctl, nil, plugins.PlanSystemctl},
+		{"environment", plugins.Environment, nil, plugins.PlanEnvironment},
+		{"systemd_firstboot", plugins.SystemdFirstboot, nil, plugins.PlanSystemdFirstboot},
+		{"datasource", plugins.DataSources, plugins.DataSourcesContext, plugins.PlanDataSources},
+		{"layout", plugins.Layout, nil, plugins.PlanLayout},
+		{"package_pins", plugins.PackagePins, nil, plugins.PlanPackagePins},
+		{"packages", plugins.Packages, plugins.PackagesContext, plugins.PlanPackages},
+		{"unpack_images", plugins.UnpackImage, nil, plugins.PlanUnpackImage},
+	}
+
+	// conditionalRegistry holds the conditionals of the executors created with NewExecutor
+	conditionalRegistry = []namedPlugin{
+		{"node", plugins.NodeConditional, nil, nil},
+		{"if", plugins.IfConditional, nil, nil},
+		{"only_os", plugins.OnlyIfOS, nil, nil},
+		{"only_os_version", plugins.OnlyIfOSVersion, nil, nil},
+		{"only_arch", plugins.IfArch, nil, nil},
+		{"only_service_manager", plugins.IfServiceManager, nil, nil},
+		{"if_files", plugins.IfFiles, nil, nil},
+	}
+)
+
+func registered(name string) bool {
+	for _, p := range append(pluginRegistry, conditionalRegistry...) {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterPlugin registers a plugin under the given name, so it's applied by the
+// executors created afterwards with NewExecutor, after the plugins already registered.
+// The planner reports the changes of the plugin in dry-run mode, and can be nil.
+func RegisterPlugin(name string, p Plugin, planner Planner) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if registered(name) {
+		return fmt.Errorf("plugin '%s' is already registered", name)
+	}
+	pluginRegistry = append(pluginRegistry, namedPlugin{name: name, plugin: p, planner: planner})
+	return nil
+}
+
+// RegisterContextPlugin is RegisterPlugin for plugins receiving the context of the run
+func RegisterContextPlugin(name string, p ContextPlugin, planner Planner) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if registered(name) {
+		return fmt.Errorf("plugin '%s' is already registered", name)
+	}
+	pluginRegistry = append(pluginRegistry, namedPlugin{name: name, ctxPlugin: p, planner: planner})
+	return nil
+}
+
+// RegisterConditional registers a conditional under the given name, so it's evaluated by the
+// executors created afterwards with NewExecutor.
+func RegisterConditional(name string, p Plugin) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if registered(name) {
+		return fmt.Errorf("conditional '%s' is already registered", name)
+	}
+	conditionalRegistry = append(conditionalRegistry, namedPlugin{name: name, plugin: p})
+	return nil
+}
+
+// PluginNames returns the names of the registered plugins
+func PluginNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return names(pluginRegistry)
+}
+
+// ConditionalNames returns the names of the registered conditionals
+func ConditionalNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return names(conditionalRegistry)
+}
+
+func names(l []namedPlugin) []string {
+	res := []string{}
+	for _, p := range l {
+		res = append(res, p.name)
+	}
+	return res
+}
+
+func registeredPlugins() []namedPlugin {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return append([]namedPlugin{}, pluginRegistry...)
+}
+
+func registeredConditionals() []namedPlugin {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return append([]namedPlugin{}, conditionalRegistry...)
+}
+
+// toNamedPlugins names the given plugins after the registry, falling back to
+// the name of the function implementing them for unregistered ones.
+func toNamedPlugins(pp []Plugin) []namedPlugin {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	res := []namedPlugin{}
+	for _, p := range pp {
+		np := namedPlugin{name: pluginName(p), plugin: p}
+		ptr := reflect.ValueOf(p).Pointer()
+		for _, r := range append(pluginRegistry, conditionalRegistry...) {
+			if reflect.ValueOf(r.plugin).Pointer() == ptr {
+				np = r
+				break
+			}
+		}
+		res = append(res, np)
+	}
+	return res
+}
+
+// filterPlugins keeps the plugins enabled and not disabled by name. An empty
+// enabled list enables all of them.
+func filterPlugins(l []namedPlugin, enabled, disabled []string) []namedPlugin {
+	res := []namedPlugin{}
+	for _, p := range l {
+		if len(enabled) > 0 && !contains(enabled, p.name) {
+			continue
+		}
+		if contains(disabled, p.name) {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// usesConditional returns whether the stage sets the field handled by the conditional,
+// which is the one named after it. Stages are considered to use conditionals not
+// matching any field, as it can't be told otherwise.
+func usesConditional(s schema.Stage, name string) bool {
+	v := reflect.ValueOf(s)
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == name {
+			return !v.Field(i).IsZero()
+		}
+	}
+	return true
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pluginName returns the name of the function implementing a plugin
+func pluginName(p interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(p).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
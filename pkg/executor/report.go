@@ -0,0 +1,200 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mudler/yip/pkg/plugins"
+)
+
+// RunReport is a machine-readable description of a yip run.
+// It is filled by the executor while running when set with WithReport.
+type RunReport struct {
+	sync.Mutex `json:"-"`
+
+	Stages         []string           `json:"stages"`
+	Start          time.Time          `json:"start"`
+	End            time.Time          `json:"end"`
+	Errors         []string           `json:"errors,omitempty"`
+	Ops            []*OpReport        `json:"ops"`
+	StageDurations map[string]float64 `json:"stage_durations_seconds,omitempty"`
+
+	stage      string
+	stageStart time.Time
+}
+
+// OpReport describes the execution of a single op, which is a step of a stage in a yip file.
+type OpReport struct {
+	report *RunReport
+
+	Source      string           `json:"source"`
+	Stage       string           `json:"stage"`
+	Name        string           `json:"name"`
+	Skipped     bool             `json:"skipped"`
+	SkipReason  string           `json:"skip_reason,omitempty"`
+	Conditional string           `json:"conditional,omitempty"`
+	Attempts    int              `json:"attempts"`
+	Duration    float64          `json:"duration_seconds"`
+	Error       string           `json:"error,omitempty"`
+	Plugins     []PluginReport   `json:"plugins,omitempty"`
+	Changes     []plugins.Change `json:"changes,omitempty"`
+	RolledBack  []string         `json:"rolled_back,omitempty"`
+}
+
+// PluginReport describes the execution of a plugin for an op
+type PluginReport struct {
+	Name     string  `json:"name"`
+	Attempt  int     `json:"attempt"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// WriteJSON writes the report as JSON
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	r.Lock()
+	defer r.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// All the recording methods are no-ops on a nil RunReport, so the executor
+// can call them unconditionally.
+
+func (r *RunReport) begin(stage string) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if r.Start.IsZero() {
+		r.Start = now
+	}
+	r.Stages = append(r.Stages, stage)
+	r.endStage(now)
+	r.stage = stage
+	r.stageStart = now
+}
+
+func (r *RunReport) finish() {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.End = time.Now()
+	r.endStage(r.End)
+}
+
+// endStage records the duration of the stage being run, if any. Must be called with the lock held.
+func (r *RunReport) endStage(now time.Time) {
+	if r.stage == "" {
+		return
+	}
+	if r.StageDurations == nil {
+		r.StageDurations = map[string]float64{}
+	}
+	r.StageDurations[r.stage] += now.Sub(r.stageStart).Seconds()
+	r.stage = ""
+}
+
+func (r *RunReport) addError(err error) {
+	if r == nil || err == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *RunReport) newOp(source, stage, name string) *OpReport {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	o := &OpReport{report: r, Source: source, Stage: stage, Name: name}
+	r.Ops = append(r.Ops, o)
+	return o
+}
+
+func (o *OpReport) skip(conditional, reason string) {
+	if o == nil {
+		return
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	o.Skipped = true
+	o.SkipReason = reason
+	o.Conditional = conditional
+}
+
+func (o *OpReport) attempt() int {
+	if o == nil {
+		return 0
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	o.Attempts++
+	return o.Attempts
+}
+
+func (o *OpReport) addPlugin(name string, attempt int, d time.Duration, err error) {
+	if o == nil {
+		return
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	p := PluginReport{Name: name, Attempt: attempt, Duration: d.Seconds()}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	o.Plugins = append(o.Plugins, p)
+}
+
+func (o *OpReport) addChanges(c []plugins.Change) {
+	if o == nil {
+		return
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	o.Changes = append(o.Changes, c...)
+}
+
+func (o *OpReport) rollback(paths []string) {
+	if o == nil {
+		return
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	o.RolledBack = append(o.RolledBack, paths...)
+}
+
+func (o *OpReport) done(d time.Duration, err error) {
+	if o == nil {
+		return
+	}
+	o.report.Lock()
+	defer o.report.Unlock()
+	o.Duration = d.Seconds()
+	if err != nil {
+		o.Error = err.Error()
+	}
+}
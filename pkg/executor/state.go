@@ -0,0 +1,88 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	prv "github.com/mudler/yip/pkg/plugins/datasourceProviders"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+// DefaultStateDir is where the executor records the stages which shouldn't be applied again
+const DefaultStateDir = "/var/lib/yip/state"
+
+// instanceIDFiles are looked up in order to find the id of the instance for per-instance stages.
+// The machine id is used when no datasource provided an instance id.
+var instanceIDFiles = []string{
+	filepath.Join(prv.ConfigPath, prv.InstanceID),
+	"/etc/machine-id",
+}
+
+func instanceID(fs vfs.FS) (string, error) {
+	for _, f := range instanceIDFiles {
+		b, err := fs.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(b)); id != "" {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no instance id found in %s", strings.Join(instanceIDFiles, ", "))
+}
+
+// statePath returns the file recording that the op of the given stage was applied.
+// An empty path is returned for stages which are always applied.
+func (e *DefaultExecutor) statePath(fs vfs.FS, stage, opName string, s schema.Stage) (string, error) {
+	switch f := s.GetFrequency(); f {
+	case schema.FrequencyAlways:
+		return "", nil
+	case schema.FrequencyOnce:
+		return filepath.Join(e.stateDir, "once", url.PathEscape(stage), url.PathEscape(opName)), nil
+	case schema.FrequencyPerInstance:
+		id, err := instanceID(fs)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(e.stateDir, "instances", url.PathEscape(id), url.PathEscape(stage), url.PathEscape(opName)), nil
+	default:
+		e.logger.Warnf("Unknown frequency '%s' for stage '%s', applying it always", f, opName)
+		return "", nil
+	}
+}
+
+func applied(fs vfs.FS, path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := fs.Stat(path)
+	return err == nil
+}
+
+func markApplied(fs vfs.FS, path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := vfs.MkdirAll(fs, filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return fs.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
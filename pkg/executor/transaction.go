@@ -0,0 +1,51 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+// runTransaction runs the stage with its retries. Files written by transactional
+// stages are backed up before the first attempt and restored if all of them fail,
+// before the on_failure hooks run.
+func (e *DefaultExecutor) runTransaction(ctx context.Context, config schema.YipConfig, stageName string, stage schema.Stage, fs vfs.FS, console plugins.Console, rep *OpReport) error {
+	if !stage.Transactional {
+		return e.runStageWithRetries(ctx, config, stageName, stage, fs, console, rep)
+	}
+
+	backup, err := plugins.BackupFiles(e.logger, stage, fs)
+	if err != nil {
+		return fmt.Errorf("failed backing up files of stage '%s': %w", stageName, err)
+	}
+
+	err = e.runStageWithRetries(ctx, config, stageName, stage, fs, console, rep)
+	if err == nil {
+		return nil
+	}
+
+	e.logger.Warnf("Stage '%s' failed, rolling back %v", stageName, backup.Paths())
+	if rerr := backup.Restore(); rerr != nil {
+		return multierror.Append(err, fmt.Errorf("failed rolling back stage '%s': %w", stageName, rerr))
+	}
+	rep.rollback(backup.Paths())
+	return err
+}
@@ -1,12 +1,14 @@
 package plugins
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 
@@ -30,6 +32,29 @@ type Console interface {
 	RunTemplate([]string, string) error
 }
 
+// ContextConsole is a Console which can bind the commands it runs to a context,
+// so they are killed when the context is cancelled.
+type ContextConsole interface {
+	Console
+	WithContext(context.Context) Console
+}
+
+// ConsoleWithContext returns the console bound to the given context, if it supports it
+func ConsoleWithContext(ctx context.Context, c Console) Console {
+	if cc, ok := c.(ContextConsole); ok {
+		return cc.WithContext(ctx)
+	}
+	return c
+}
+
+// systemDump are the options to dump the system data with, set once as ops can run concurrently
+var systemDump = func() litter.Options {
+	o := litter.Config
+	o.HideZeroValues = true
+	o.HidePrivateFields = true
+	return o
+}()
+
 func templateSysData(l logger.Interface, s string) string {
 	interpolateOpts := map[string]interface{}{}
 
@@ -38,8 +63,6 @@ func templateSysData(l logger.Interface, s string) string {
 		l.Warn(fmt.Sprintf("Failed marshalling '%s': %s", s, err.Error()))
 		return s
 	}
-	litter.Config.HideZeroValues = true
-	litter.Config.HidePrivateFields = true
 
 	// Protect against panic in litter.Sdump
 	// We suspect some struct fields might cause it to panic
@@ -55,7 +78,7 @@ func templateSysData(l logger.Interface, s string) string {
 				l.Warn(fmt.Sprintf("litter.Sdump panicked: %v", r))
 			}
 		}()
-		l.Trace(litter.Sdump(&system))
+		l.Trace(systemDump.Sdump(&system))
 	}()
 
 	err = json.Unmarshal(data, &interpolateOpts)
@@ -79,16 +102,25 @@ func templateSysData(l logger.Interface, s string) string {
 	return rendered
 }
 
-func download(url string) (string, error) {
+func download(ctx context.Context, url string) (string, error) {
 	var resp *http.Response
 	var err error
 	client := getHttpClient()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed while getting file")
+	}
+RETRY:
 	for i := 0; i < 10; i++ {
-		resp, err = client.Get(url)
+		resp, err = client.Do(req)
 		if err == nil || strings.Contains(err.Error(), "unsupported protocol scheme") {
 			break
 		}
-		time.Sleep(time.Second)
+		select {
+		case <-ctx.Done():
+			break RETRY
+		case <-time.After(time.Second):
+		}
 	}
 	if err != nil {
 		return "", errors.Wrap(err, "failed while getting file")
@@ -114,3 +146,14 @@ func getHttpClient() *http.Client {
 		},
 	}
 }
+
+// sortedKeys returns the keys of a map in lexicographic order. Plugins iterate
+// the map fields of a stage in this order, so they're applied reproducibly.
+func sortedKeys[K ~string, T any](m map[K]T) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -3,6 +3,7 @@ package plugins
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -35,7 +36,43 @@ func unique(stringSlice []string) []string {
 	return list
 }
 
+// probe probes the provider, giving up when the context is cancelled
+func probe(ctx context.Context, p prv.Provider) bool {
+	res := make(chan bool, 1)
+	go func() { res <- p.Probe() }()
+	select {
+	case <-ctx.Done():
+		return false
+	case found := <-res:
+		return found
+	}
+}
+
+// extract extracts the userdata from the provider, giving up when the context is cancelled
+func extract(ctx context.Context, p prv.Provider) ([]byte, error) {
+	type result struct {
+		userdata []byte
+		err      error
+	}
+	res := make(chan result, 1)
+	go func() {
+		userdata, err := p.Extract()
+		res <- result{userdata, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-res:
+		return r.userdata, r.err
+	}
+}
+
 func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return DataSourcesContext(context.Background(), l, s, fs, console)
+}
+
+// DataSourcesContext is DataSources, giving up probing the providers when the context is cancelled
+func DataSourcesContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var AvailableProviders []prv.Provider
 	var CdromProviders []prv.Provider
 
@@ -102,8 +139,8 @@ func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 	// Run first cdrom providers
 	for _, p = range CdromProviders {
 		l.Debugf("Starting provider %s", p.String())
-		if p.Probe() {
-			userdata, err = p.Extract()
+		if probe(ctx, p) {
+			userdata, err = extract(ctx, p)
 			if err != nil {
 				l.Warnf("Failed extracting data from %s provider: %s", p.String(), err.Error())
 			}
@@ -123,8 +160,8 @@ func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 			wg.Add(1)
 			go func(l logger.Interface, p prv.Provider) {
 				defer wg.Done()
-				if p.Probe() {
-					userdata, err := p.Extract()
+				if probe(ctx, p) {
+					userdata, err := extract(ctx, p)
 					if err != nil {
 						l.Warnf("Failed extracting data from %s provider: %s", p.String(), err.Error())
 						return
@@ -151,6 +188,10 @@ func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "probing datasources")
+	}
+
 	if userdata == nil {
 		return fmt.Errorf("no metadata/userdata found")
 	}
@@ -180,7 +221,7 @@ func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 
 	//Apply the authorized_keys if the provider extracted a ssh/authorized_keys file
 	if _, err := fs.Stat(path.Join(prv.ConfigPath, prv.SSH, authorizedFile)); err == nil {
-		if err := processSSHFile(l, fs, console); err != nil {
+		if err := processSSHFile(ctx, l, fs, console); err != nil {
 			return err
 		}
 	}
@@ -196,7 +237,7 @@ func processHostnameFile(l logger.Interface, fs vfs.FS, console Console) error {
 	return Hostname(l, schema.Stage{Hostname: string(hostname)}, fs, console)
 }
 
-func processSSHFile(l logger.Interface, fs vfs.FS, console Console) error {
+func processSSHFile(ctx context.Context, l logger.Interface, fs vfs.FS, console Console) error {
 	auth_keys, err := fs.ReadFile(path.Join(prv.ConfigPath, prv.SSH, authorizedFile))
 	if err != nil {
 		return err
@@ -215,7 +256,7 @@ func processSSHFile(l logger.Interface, fs vfs.FS, console Console) error {
 			keys = append(keys, line)
 		}
 	}
-	return SSH(l, schema.Stage{SSHKeys: map[string][]string{usr.Username: keys}}, fs, console)
+	return SSHContext(ctx, l, schema.Stage{SSHKeys: map[string][]string{usr.Username: keys}}, fs, console)
 }
 
 // DecodeMultipartVmware will try to decode the user-data from VMWARE provider as it returns a
@@ -252,7 +293,8 @@ func processUserData(l logger.Interface, basePath string, data []byte, userdataN
 		return err
 	}
 
-	if _, err := schema.Load(dataS, fs, nil, nil); err == nil {
+	// Includes are resolved when the saved userdata is applied, relative to it
+	if _, err := schema.Load(dataS, fs, nil, nil, schema.SkipIncludes()); err == nil {
 		return writeToFile(l, path.Join(basePath, userdataName), dataS, 0644, fs, console)
 	}
 
@@ -27,6 +27,9 @@ const (
 
 	// SSH is the path where sshd configuration from the provider is stored
 	SSH = "ssh"
+
+	// InstanceID is the filename in configPath where the instance id is stored
+	InstanceID = "instance_id"
 )
 
 // Provider is a generic interface for metadata/userdata providers.
@@ -132,6 +132,27 @@ var _ = Describe("Datasources", Label("datasource"), func() {
 			// Data should match in the file
 			Expect(string(file)).To(Equal(cloudConfigData))
 		})
+		It("Saves yip userdata with includes without resolving them", func() {
+			yipData := "include:\n- base.yaml\n- https://example.invalid/yip.yaml\nstages:\n  boot:\n  - commands: [echo test]\n"
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/oem": ""})
+			Expect(err).ToNot(HaveOccurred())
+			defer cleanup()
+			temp, err := os.MkdirTemp("", "yip-xxx")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(temp)
+			err = os.WriteFile(filepath.Join(temp, "datasource"), []byte(yipData), os.ModePerm)
+			Expect(err).ToNot(HaveOccurred())
+			err = DataSources(l, schema.Stage{
+				DataSources: schema.DataSource{
+					Providers: []string{"file"},
+					Path:      filepath.Join(temp, "datasource"),
+				},
+			}, fs, &testConsole)
+			Expect(err).ToNot(HaveOccurred())
+			file, err := fs.ReadFile(filepath.Join(providers.ConfigPath, "userdata.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(file)).To(Equal(yipData))
+		})
 		It("Properly decodes VMWARE datasource", func() {
 			vmwareData := []byte(`Content-Type: multipart/mixed; boundary="MIMEBOUNDARY"
 MIME-Version: 1.0
@@ -18,38 +18,50 @@ func DNS(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 }
 
 func applyDNS(s schema.Stage) error {
-	path := s.Dns.Path
-	if path == "" {
-		path = "/etc/resolv.conf"
-	}
-	err := Build(path, s.Dns.Nameservers, s.Dns.DnsSearch, s.Dns.DnsOptions)
+	err := Build(dnsPath(s), s.Dns.Nameservers, s.Dns.DnsSearch, s.Dns.DnsOptions)
 	return err
 }
 
 func Build(path string, nameservers, dnsSearch, dnsOptions []string) error {
+	content, err := resolvConf(nameservers, dnsSearch, dnsOptions)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	return nil
+}
+
+func dnsPath(s schema.Stage) string {
+	if s.Dns.Path == "" {
+		return "/etc/resolv.conf"
+	}
+	return s.Dns.Path
+}
+
+// resolvConf renders the resolv.conf content for the given settings
+func resolvConf(nameservers, dnsSearch, dnsOptions []string) ([]byte, error) {
 	content := bytes.NewBuffer(nil)
 	if len(dnsSearch) > 0 {
 		if searchString := strings.Join(dnsSearch, " "); strings.Trim(searchString, " ") != "." {
 			if _, err := content.WriteString("search " + searchString + "\n"); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
 	for _, dns := range nameservers {
 		if _, err := content.WriteString("nameserver " + dns + "\n"); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	if len(dnsOptions) > 0 {
 		if optsString := strings.Join(dnsOptions, " "); strings.Trim(optsString, " ") != "" {
 			if _, err := content.WriteString("options " + optsString + "\n"); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
-
-	if err := os.WriteFile(path, content.Bytes(), 0o644); err != nil {
-		return err
-	}
-	return nil
+	return content.Bytes(), nil
 }
@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"time"
@@ -28,6 +29,11 @@ func grabClient(timeout int) *grab.Client {
 }
 
 func Download(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return DownloadContext(context.Background(), l, s, fs, console)
+}
+
+// DownloadContext is Download, interrupting the downloads when the context is cancelled
+func DownloadContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	for _, dl := range s.Downloads {
 		d := &dl
@@ -35,7 +41,7 @@ func Download(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 		if err == nil {
 			d.Path = realPath
 		}
-		if err := downloadFile(l, *d); err != nil {
+		if err := downloadFile(ctx, l, *d); err != nil {
 			log.Error(err.Error())
 			errs = multierror.Append(errs, err)
 			continue
@@ -44,7 +50,7 @@ func Download(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 	return errs
 }
 
-func downloadFile(l logger.Interface, dl schema.Download) error {
+func downloadFile(ctx context.Context, l logger.Interface, dl schema.Download) error {
 	l.Debug("Downloading file ", dl.Path, dl.URL)
 	client := grabClient(dl.Timeout)
 
@@ -52,7 +58,7 @@ func downloadFile(l logger.Interface, dl schema.Download) error {
 	if err != nil {
 		return err
 	}
-	resp := client.Do(req)
+	resp := client.Do(req.WithContext(ctx))
 
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
@@ -15,10 +15,14 @@
 package plugins_test
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"time"
 
 	. "github.com/mudler/yip/pkg/plugins"
 	"github.com/mudler/yip/pkg/schema"
@@ -74,5 +78,25 @@ var _ = Describe("Download", func() {
 
 			Expect(string(b)).Should(Equal("test"))
 		})
+		It("stops downloading when the context is cancelled", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/tmp/test/bar": "boo"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			err = DownloadContext(ctx, l, schema.Stage{
+				Downloads: []schema.Download{{Path: "/tmp/test/foo", URL: srv.URL, Permissions: 0777}},
+			}, fs, &testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
 	})
 })
@@ -55,8 +55,8 @@ func Environment(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 	}
 
 	env, _ := godotenv.Unmarshal(string(content))
-	for key, val := range s.Environment {
-		env[key] = templateSysData(l, val)
+	for _, key := range sortedKeys(s.Environment) {
+		env[key] = templateSysData(l, s.Environment[key])
 	}
 
 	p, err := fs.RawPath(environment)
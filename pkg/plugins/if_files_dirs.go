@@ -10,7 +10,8 @@ import (
 
 func IfFiles(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	if len(s.IfFiles) > 0 {
-		for check, files := range s.IfFiles {
+		for _, check := range sortedKeys(s.IfFiles) {
+			files := s.IfFiles[check]
 			switch check {
 			// Check that all files exist
 			case schema.IfCheckAll:
@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -52,8 +53,14 @@ const (
 // If it can't identify the package manager, it will return an error
 // Order is Refresh -> Upgrade -> Install -> Remove
 func Packages(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return PackagesContext(context.Background(), l, s, fs, console)
+}
+
+// PackagesContext is Packages, deferring the reboot required by the packages if the
+// context asks to (see DeferReboot)
+func PackagesContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	// Don't do anything if empty
-	if len(s.Packages.Remove) == 0 && len(s.Packages.Install) == 0 && s.Packages.Refresh == false {
+	if len(s.Packages.Remove) == 0 && len(s.Packages.Install) == 0 && !s.Packages.Refresh && !s.Packages.Upgrade {
 		return nil
 	}
 
@@ -128,7 +135,11 @@ func Packages(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 
 	if s.Packages.Install != nil {
 		// Run install
-		installArgs = append(installArgs, s.Packages.Install...)
+		specs, err := packageSpecs(cmd, s.Packages.Install)
+		if err != nil {
+			return err
+		}
+		installArgs = append(installArgs, specs...)
 		l.Debugf("Running install")
 		out, err := console.Run(templateSysData(l, strings.Join(append([]string{cmd.String()}, installArgs...), " ")))
 		if err != nil {
@@ -158,9 +169,40 @@ func Packages(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 		}
 	}
 
+	if s.Packages.RebootIfRequired && (s.Packages.Upgrade || len(s.Packages.Install) > 0) {
+		if _, err := fs.Stat(rebootRequiredFile); err == nil {
+			l.Infof("Rebooting, %s exists after installing packages", rebootRequiredFile)
+			return reboot(ctx, l, console)
+		}
+	}
+
 	return nil
 }
 
+// rebootRequiredFile is created by apt when an installed package requires a reboot
+const rebootRequiredFile = "/var/run/reboot-required"
+
+// packageSpecs returns the packages to install in the format of the installer.
+// Versions are given as name=version, dnf expects name-version instead, and pacman
+// can only install the version in the repositories.
+func packageSpecs(installer Installer, packages []string) ([]string, error) {
+	switch installer {
+	case DNFInstaller:
+		res := []string{}
+		for _, p := range packages {
+			res = append(res, strings.Replace(p, "=", "-", 1))
+		}
+		return res, nil
+	case PacmanInstaller:
+		for _, p := range packages {
+			if strings.Contains(p, "=") {
+				return nil, fmt.Errorf("can't install '%s': pacman doesn't support installing a given version", p)
+			}
+		}
+	}
+	return packages, nil
+}
+
 // identifyInstaller returns the package manager based on the distro
 func identifyInstaller(fsys vfs.FS) Installer {
 	identifiedInstaller := UnknownInstaller
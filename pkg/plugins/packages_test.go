@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"context"
 	"io"
 
 	"github.com/mudler/yip/pkg/schema"
@@ -110,6 +111,65 @@ var _ = Describe("Commands", Label("packages"), func() {
 				testConsole.Reset()
 			}
 		})
+		It("upgrades packages without installing any", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": "ID=debian\nVERSION=10\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = Packages(l, schema.Stage{Packages: schema.Packages{Upgrade: true}}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(testConsole.Commands).Should(Equal([]string{"apt-get -y upgrade"}))
+		})
+		It("installs versions in the format of the package manager", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": "ID=fedora\nVERSION=34\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = Packages(l, schema.Stage{Packages: schema.Packages{Install: []string{"foo=1.2-3", "bar"}}}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(testConsole.Commands).Should(Equal([]string{"dnf install -y --setopt=install_weak_deps=False foo-1.2-3 bar"}))
+		})
+		It("reboots only if required after installing packages", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": "ID=debian\nVERSION=10\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			stage := schema.Stage{Packages: schema.Packages{Install: []string{"foo"}, RebootIfRequired: true}}
+			Expect(Packages(l, stage, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).Should(Equal([]string{"apt-get -y --no-install-recommends install foo"}))
+
+			testConsole.Reset()
+			Expect(fs.Mkdir("/var", 0755)).To(Succeed())
+			Expect(fs.Mkdir("/var/run", 0755)).To(Succeed())
+			Expect(fs.WriteFile("/var/run/reboot-required", []byte("*** System restart required ***\n"), 0644)).To(Succeed())
+			Expect(Packages(l, stage, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).Should(Equal([]string{"apt-get -y --no-install-recommends install foo", "reboot"}))
+		})
+		It("defers the reboot if the context asks to", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/etc/os-release":          "ID=debian\nVERSION=10\n",
+				"/var/run/reboot-required": "*** System restart required ***\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			ctx, rebootRequested := DeferReboot(context.Background())
+			Expect(rebootRequested()).To(BeFalse())
+			stage := schema.Stage{Packages: schema.Packages{Install: []string{"foo"}, RebootIfRequired: true}}
+			Expect(PackagesContext(ctx, l, stage, fs, &testConsole)).To(Succeed())
+			Expect(testConsole.Commands).Should(Equal([]string{"apt-get -y --no-install-recommends install foo"}))
+			Expect(rebootRequested()).To(BeTrue())
+		})
+		It("fails installing versions with pacman", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/os-release": "ID=arch\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = Packages(l, schema.Stage{Packages: schema.Packages{Install: []string{"foo", "bar=1.2-3"}}}, fs, &testConsole)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("can't install 'bar=1.2-3'"))
+			Expect(testConsole.Commands).To(BeEmpty())
+		})
 		It("fails if it cant identify the systems package manager", func() {
 			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
 			Expect(err).Should(BeNil())
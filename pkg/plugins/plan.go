@@ -0,0 +1,376 @@
+package plugins
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/joho/godotenv"
+	entities "github.com/mudler/entities/pkg/entities"
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/mudler/yip/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v5"
+	"gopkg.in/ini.v1"
+)
+
+// Change describes a single modification that a plugin would apply to the system.
+// Changes are returned by the Plan* functions, which are the dry-run counterpart
+// of the plugins: they inspect the system and the stage without mutating anything.
+type Change struct {
+	Plugin  string `json:"plugin"`
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	Details string `json:"details,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s: %s %s", c.Plugin, c.Action, c.Target)
+	if c.Details != "" {
+		s += fmt.Sprintf(" (%s)", c.Details)
+	}
+	return s
+}
+
+// fileChange returns the change needed to bring path to the given content
+func fileChange(plugin string, fs vfs.FS, path, content string) Change {
+	current, err := fs.ReadFile(path)
+	if err != nil {
+		return Change{Plugin: plugin, Action: "create", Target: path, Diff: utils.Diff("", content)}
+	}
+	if string(current) == content {
+		return Change{Plugin: plugin, Action: "unchanged", Target: path}
+	}
+	return Change{Plugin: plugin, Action: "update", Target: path, Diff: utils.Diff(string(current), content)}
+}
+
+func PlanDNS(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Dns.Nameservers) == 0 {
+		return nil, nil
+	}
+	content, err := resolvConf(s.Dns.Nameservers, s.Dns.DnsSearch, s.Dns.DnsOptions)
+	if err != nil {
+		return nil, err
+	}
+	// DNS writes directly on the host, ignoring fs
+	return []Change{fileChange("dns", vfs.OSFS, dnsPath(s), string(content))}, nil
+}
+
+func PlanDownload(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, dl := range s.Downloads {
+		changes = append(changes, Change{Plugin: "downloads", Action: "download", Target: dl.Path, Details: dl.URL})
+	}
+	return changes, nil
+}
+
+func PlanGit(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if s.Git.URL == "" {
+		return nil, nil
+	}
+	action := "clone"
+	if _, err := fs.Stat(filepath.Join(s.Git.Path, ".git")); err == nil {
+		action = "update"
+	}
+	details := s.Git.URL
+	if s.Git.Branch != "" {
+		details = fmt.Sprintf("%s@%s", s.Git.URL, s.Git.Branch)
+	}
+	return []Change{{Plugin: "git", Action: action, Target: s.Git.Path, Details: details}}, nil
+}
+
+func planEntities(l logger.Interface, action string, list []schema.YipEntity) ([]Change, error) {
+	var changes []Change
+	var errs error
+	entityParser := entities.Parser{}
+	for _, e := range list {
+		decodedE, err := entityParser.ReadEntityFromBytes([]byte(templateSysData(l, e.Entity)))
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		changes = append(changes, Change{Plugin: "entities", Action: action, Target: e.Path, Details: fmt.Sprintf("%s %s", decodedE.GetKind(), decodedE.String())})
+	}
+	return changes, errs
+}
+
+func PlanEntities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	return planEntities(l, "ensure", s.EnsureEntities)
+}
+
+func PlanDeleteEntities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	return planEntities(l, "delete", s.DeleteEntities)
+}
+
+func PlanEnsureDirectories(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, dir := range s.Directories {
+		details := fmt.Sprintf("mode %04o, owner %d:%d", dir.Permissions, dir.Owner, dir.Group)
+		inf, err := fs.Stat(dir.Path)
+		switch {
+		case err != nil:
+			changes = append(changes, Change{Plugin: "directories", Action: "create", Target: dir.Path, Details: details})
+		case !inf.IsDir():
+			return changes, fmt.Errorf("Error, '%s' already exists and it is not a directory", dir.Path)
+		default:
+			changes = append(changes, Change{Plugin: "directories", Action: "update", Target: dir.Path, Details: details})
+		}
+	}
+	return changes, nil
+}
+
+func PlanEnsureFiles(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, file := range s.Files {
+		c, err := newDecoder(file.Encoding).Decode(file.Content)
+		if err != nil {
+			return changes, errors.Wrapf(err, "failed decoding content with encoding %s", file.Encoding)
+		}
+		change := fileChange("files", fs, file.Path, templateSysData(l, string(c)))
+		change.Details = fmt.Sprintf("mode %04o", file.Permissions)
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func PlanCommands(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, cmd := range s.Commands {
+		changes = append(changes, Change{Plugin: "commands", Action: "run", Target: templateSysData(l, cmd)})
+	}
+	return changes, nil
+}
+
+func PlanHostname(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if s.Hostname == "" {
+		return nil, nil
+	}
+	return []Change{{Plugin: "hostname", Action: "set", Target: s.Hostname, Details: "/etc/hostname, /etc/hosts"}}, nil
+}
+
+func PlanSysctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, k := range sortedKeys(s.Sysctl) {
+		path := filepath.Join(append(procSys, strings.Split(k, ".")...)...)
+		changes = append(changes, fileChange("sysctl", fs, path, s.Sysctl[k]))
+	}
+	return changes, nil
+}
+
+func PlanUser(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, k := range sortedKeys(s.Users) {
+		r := s.Users[k]
+		r.Name = k
+		switch {
+		case !r.Exists():
+			changes = append(changes, Change{Plugin: "users", Action: "create", Target: k, Details: strings.Join(r.Groups, ",")})
+		case r.PasswordHash != "":
+			changes = append(changes, Change{Plugin: "users", Action: "set password", Target: k})
+		default:
+			changes = append(changes, Change{Plugin: "users", Action: "unchanged", Target: k})
+		}
+		for _, key := range r.SSHAuthorizedKeys {
+			changes = append(changes, Change{Plugin: "users", Action: "authorize key", Target: k, Details: key})
+		}
+	}
+	return changes, nil
+}
+
+func PlanSSH(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, u := range sortedKeys(s.SSHKeys) {
+		for _, key := range s.SSHKeys[u] {
+			changes = append(changes, Change{Plugin: "authorized_keys", Action: "authorize key", Target: u, Details: key})
+		}
+	}
+	return changes, nil
+}
+
+func PlanLoadModules(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Modules) == 0 {
+		return nil, nil
+	}
+	var changes []Change
+	loaded := loadedModules(l, fs)
+	for _, m := range s.Modules {
+		if _, ok := loaded[m]; ok {
+			continue
+		}
+		changes = append(changes, Change{Plugin: "modules", Action: "load", Target: m})
+	}
+	return changes, nil
+}
+
+func PlanTimesyncd(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.TimeSyncd) == 0 {
+		return nil, nil
+	}
+	cfg := ini.Empty()
+	if current, err := fs.ReadFile(timeSyncd); err == nil {
+		cfg, err = ini.Load(current)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range sortedKeys(s.TimeSyncd) {
+		cfg.Section("Time").Key(k).SetValue(s.TimeSyncd[k])
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := cfg.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return []Change{fileChange("timesyncd", fs, timeSyncd, buf.String())}, nil
+}
+
+func PlanSystemctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, action := range []struct {
+		name  string
+		units []string
+	}{
+		{"enable", s.Systemctl.Enable},
+		{"disable", s.Systemctl.Disable},
+		{"mask", s.Systemctl.Mask},
+		{"start", s.Systemctl.Start},
+	} {
+		for _, unit := range action.units {
+			changes = append(changes, Change{Plugin: "systemctl", Action: action.name, Target: unit})
+		}
+	}
+	for _, override := range s.Systemctl.Overrides {
+		overrideFile, ok := overridePath(l, override)
+		if !ok {
+			continue
+		}
+		changes = append(changes, fileChange("systemctl", fs, overrideFile, override.Content))
+	}
+	return changes, nil
+}
+
+func PlanEnvironment(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Environment) == 0 {
+		return nil, nil
+	}
+	environment := s.EnvironmentFile
+	if environment == "" {
+		environment = environmentFile
+	}
+
+	env := map[string]string{}
+	if content, err := fs.ReadFile(environment); err == nil {
+		env, _ = godotenv.Unmarshal(string(content))
+	}
+	for _, key := range sortedKeys(s.Environment) {
+		env[key] = templateSysData(l, s.Environment[key])
+	}
+	content, err := godotenv.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return []Change{fileChange("environment", fs, environment, content+"\n")}, nil
+}
+
+func PlanSystemdFirstboot(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	args := firstbootArgs(s)
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return []Change{{Plugin: "systemd_firstboot", Action: "run", Target: fmt.Sprintf("systemd-firstboot %s", strings.Join(args, " "))}}, nil
+}
+
+func PlanDataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.DataSources.Providers) == 0 {
+		return nil, nil
+	}
+	return []Change{{Plugin: "datasource", Action: "fetch userdata", Target: strings.Join(unique(s.DataSources.Providers), ","), Details: s.DataSources.Path}}, nil
+}
+
+func PlanLayout(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if s.Layout.Device == nil {
+		return nil, nil
+	}
+	device := s.Layout.Device.Path
+	if device == "" {
+		device = fmt.Sprintf("label=%s", s.Layout.Device.Label)
+	}
+	var changes []Change
+	if s.Layout.Device.InitDisk {
+		changes = append(changes, Change{Plugin: "layout", Action: "initialize partition table", Target: device})
+	}
+	for _, part := range s.Layout.Parts {
+		fsType := part.FileSystem
+		if fsType == "" {
+			fsType = Ext2
+		}
+		changes = append(changes, Change{
+			Plugin:  "layout",
+			Action:  "add partition",
+			Target:  device,
+			Details: fmt.Sprintf("size %d MiB, filesystem %s, fsLabel %q, pLabel %q", part.Size, fsType, part.FSLabel, part.PLabel),
+		})
+	}
+	if s.Layout.Expand != nil {
+		changes = append(changes, Change{Plugin: "layout", Action: "expand last partition", Target: device, Details: fmt.Sprintf("size %d MiB", s.Layout.Expand.Size)})
+	}
+	return changes, nil
+}
+
+func PlanPackagePins(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	installer := identifyInstaller(fs)
+	for _, k := range sortedKeys(s.PackagePins) {
+		changes = append(changes, Change{Plugin: "package_pins", Action: "pin", Target: fmt.Sprintf("%s=%s", k, s.PackagePins[k]), Details: installer.String()})
+	}
+	return changes, nil
+}
+
+func PlanPackages(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Packages.Remove) == 0 && len(s.Packages.Install) == 0 && !s.Packages.Refresh && !s.Packages.Upgrade {
+		return nil, nil
+	}
+	installer := identifyInstaller(fs)
+	if installer == UnknownInstaller {
+		return nil, fmt.Errorf("unknown package manager")
+	}
+	var changes []Change
+	if s.Packages.Refresh {
+		changes = append(changes, Change{Plugin: "packages", Action: "refresh", Target: installer.String()})
+	}
+	if s.Packages.Upgrade {
+		changes = append(changes, Change{Plugin: "packages", Action: "upgrade", Target: installer.String()})
+	}
+	specs, err := packageSpecs(installer, s.Packages.Install)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range specs {
+		changes = append(changes, Change{Plugin: "packages", Action: "install", Target: p, Details: installer.String()})
+	}
+	for _, p := range s.Packages.Remove {
+		changes = append(changes, Change{Plugin: "packages", Action: "remove", Target: p, Details: installer.String()})
+	}
+	if s.Packages.RebootIfRequired && (s.Packages.Upgrade || len(s.Packages.Install) > 0) {
+		changes = append(changes, Change{Plugin: "packages", Action: "reboot", Target: "system", Details: fmt.Sprintf("at the end of the run, if %s exists after installing packages", rebootRequiredFile)})
+	}
+	return changes, nil
+}
+
+func PlanUnpackImage(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, imageConf := range s.UnpackImages {
+		if imageConf.Source == "" || imageConf.Target == "" {
+			continue
+		}
+		details := imageConf.Source
+		if imageConf.Platform != "" {
+			details = fmt.Sprintf("%s (%s)", imageConf.Source, imageConf.Platform)
+		}
+		changes = append(changes, Change{Plugin: "unpack_images", Action: "unpack", Target: imageConf.Target, Details: details})
+	}
+	return changes, nil
+}
@@ -0,0 +1,90 @@
+package plugins_test
+
+import (
+	"io"
+
+	. "github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	consoletests "github.com/mudler/yip/tests/console"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v5/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Plan", func() {
+	Context("planning changes", func() {
+		testConsole := consoletests.TestConsole{}
+		l := logrus.New()
+		l.SetOutput(io.Discard)
+
+		AfterEach(func() {
+			testConsole.Reset()
+		})
+
+		It("reports file diffs without writing", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/tmp/test/bar": "boo\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			changes, err := PlanEnsureFiles(l, schema.Stage{
+				Files: []schema.File{
+					{Path: "/tmp/test/bar", Content: "baz\n", Permissions: 0644},
+					{Path: "/tmp/test/foo", Content: "foo\n", Permissions: 0600},
+					{Path: "/tmp/test/bar", Content: "boo\n", Permissions: 0644},
+				},
+			}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(HaveLen(3))
+			Expect(changes[0].Action).To(Equal("update"))
+			Expect(changes[0].Diff).To(Equal("-boo\n+baz\n"))
+			Expect(changes[1].Action).To(Equal("create"))
+			Expect(changes[1].Diff).To(Equal("+foo\n"))
+			Expect(changes[2].Action).To(Equal("unchanged"))
+
+			_, err = fs.Stat("/tmp/test/foo")
+			Expect(err).Should(HaveOccurred())
+			b, err := fs.ReadFile("/tmp/test/bar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).To(Equal("boo\n"))
+		})
+
+		It("reports commands and systemctl actions without running them", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			changes, err := PlanCommands(l, schema.Stage{Commands: []string{"echo foo"}}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(Equal([]Change{{Plugin: "commands", Action: "run", Target: "echo foo"}}))
+
+			changes, err = PlanSystemctl(l, schema.Stage{Systemctl: schema.Systemctl{
+				Enable:    []string{"foo"},
+				Overrides: []schema.SystemctlOverride{{Service: "foo", Content: "[Service]\n"}},
+			}}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(HaveLen(2))
+			Expect(changes[0].String()).To(Equal("systemctl: enable foo"))
+			Expect(changes[1].Action).To(Equal("create"))
+			Expect(changes[1].Target).To(Equal("/etc/systemd/system/foo.service.d/override-yip.conf"))
+
+			Expect(testConsole.Commands).To(BeEmpty())
+		})
+
+		It("reports sysctl values", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/proc/sys/debug/exception-trace": "1"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			changes, err := PlanSysctl(l, schema.Stage{
+				Sysctl: map[string]string{"debug.exception-trace": "0", "debug.kprobes-optimization": "1"},
+			}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(HaveLen(2))
+			Expect(changes[0].Target).To(Equal("/proc/sys/debug/exception-trace"))
+			Expect(changes[0].Action).To(Equal("update"))
+			Expect(changes[1].Action).To(Equal("create"))
+		})
+	})
+})
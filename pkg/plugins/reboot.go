@@ -0,0 +1,33 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mudler/yip/pkg/logger"
+)
+
+type rebootKey struct{}
+
+// DeferReboot returns a context in which the plugins requiring a reboot don't reboot
+// the system right away, but record it. The returned function reports whether any
+// of them did, so the caller reboots once the run is done.
+func DeferReboot(ctx context.Context) (context.Context, func() bool) {
+	requested := &atomic.Bool{}
+	return context.WithValue(ctx, rebootKey{}, requested), requested.Load
+}
+
+// reboot reboots the system, or records the request if the reboot is deferred by the context
+func reboot(ctx context.Context, l logger.Interface, console Console) error {
+	if requested, ok := ctx.Value(rebootKey{}).(*atomic.Bool); ok {
+		l.Infof("Deferring the reboot to the end of the run")
+		requested.Store(true)
+		return nil
+	}
+	out, err := console.Run("reboot")
+	if err != nil {
+		l.Debug(fmt.Sprintf("Command output: %s", out))
+	}
+	return err
+}
@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"os"
@@ -28,10 +29,15 @@ var keyProviders = map[string]string{
 }
 
 func SSH(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return SSHContext(context.Background(), l, s, fs, console)
+}
+
+// SSHContext is SSH, fetching remote keys with the given context
+func SSHContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 
-	for u, keys := range s.SSHKeys {
-		if err := ensureKeys(u, keys, fs); err != nil {
+	for _, u := range sortedKeys(s.SSHKeys) {
+		if err := ensureKeys(ctx, u, s.SSHKeys[u], fs); err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
@@ -39,7 +45,7 @@ func SSH(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	return errs
 }
 
-func getRemotePubKey(key string) (string, error) {
+func getRemotePubKey(ctx context.Context, key string) (string, error) {
 	url, err := url.Parse(key)
 	if err != nil {
 		return "", err
@@ -49,7 +55,7 @@ func getRemotePubKey(key string) (string, error) {
 		key = fmt.Sprintf(providerURL, url.Opaque)
 	}
 
-	out, err := download(key)
+	out, err := download(ctx, key)
 	if err != nil {
 		return "", errors.Wrap(err, "failed while downloading key")
 	}
@@ -80,11 +86,11 @@ func ensure(file string, fs vfs.FS) (os.FileInfo, error) {
 	return info, nil
 }
 
-func authorizeSSHKey(key, file string, uid, gid int, fs vfs.FS) error {
+func authorizeSSHKey(ctx context.Context, key, file string, uid, gid int, fs vfs.FS) error {
 	var err error
 
 	if utils.IsUrl(key) {
-		key, err = getRemotePubKey(key)
+		key, err = getRemotePubKey(ctx, key)
 		if err != nil {
 			return errors.Wrap(err, "failed fetching ssh key")
 		}
@@ -116,7 +122,7 @@ func authorizeSSHKey(key, file string, uid, gid int, fs vfs.FS) error {
 	return fs.Chown(file, uid, gid)
 }
 
-func ensureKeys(user string, keys []string, fs vfs.FS) error {
+func ensureKeys(ctx context.Context, user string, keys []string, fs vfs.FS) error {
 	var errs error
 	f, err := fs.RawPath(passwdFile)
 
@@ -159,7 +165,7 @@ func ensureKeys(user string, keys []string, fs vfs.FS) error {
 
 	userAuthorizedFile := path.Join(userSSHDir, authorizedFile)
 	for _, key := range keys {
-		if err = authorizeSSHKey(key, userAuthorizedFile, uid, gid, fs); err != nil {
+		if err = authorizeSSHKey(ctx, key, userAuthorizedFile, uid, gid, fs); err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
@@ -16,7 +16,8 @@ var (
 
 func Sysctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
-	for k, v := range s.Sysctl {
+	for _, k := range sortedKeys(s.Sysctl) {
+		v := s.Sysctl[k]
 		elements := procSys
 		elements = append(elements, strings.Split(k, ".")...)
 		path := filepath.Join(elements...)
@@ -35,38 +35,47 @@ func Systemctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) e
 		errs = multierror.Append(errs, err)
 	}
 	for _, override := range s.Systemctl.Overrides {
-		// Skip empty overrides or empty content
-		if override.Service == EmptyString {
-			l.Warnf(ErrorEmptyOverrideService)
+		overrideFile, ok := overridePath(l, override)
+		if !ok {
 			continue
 		}
-		if override.Content == EmptyString {
-			l.Warnf(ErrorEmptyOverrideContent, override.Service)
-			continue
-		}
-		// Override name is optional, default to override-yip.conf
-		if override.Name == EmptyString {
-			override.Name = DefaultOverrideName
-		}
-		// Ensure the extension is .conf
-		if filepath.Ext(override.Name) != DefaultOverrideExt {
-			override.Name = override.Name + DefaultOverrideExt
-		}
-		// Ensure the service has a .service extension
-		if filepath.Ext(override.Service) != DefaultServiceExt {
-			override.Service = override.Service + DefaultServiceExt
-		}
 		// Create the override directory
-		overrideDir := fmt.Sprintf(DefaultOverrideDir, override.Service)
-		err := vfs.MkdirAll(fs, overrideDir, 0755)
+		err := vfs.MkdirAll(fs, filepath.Dir(overrideFile), 0755)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
 		// Write the override file content
-		err = fs.WriteFile(filepath.Join(overrideDir, override.Name), []byte(override.Content), 0644)
+		err = fs.WriteFile(overrideFile, []byte(override.Content), 0644)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
 	return errs
 }
+
+// overridePath returns the drop-in file path for a systemd override.
+// It returns false if the override has to be skipped.
+func overridePath(l logger.Interface, override schema.SystemctlOverride) (string, bool) {
+	// Skip empty overrides or empty content
+	if override.Service == EmptyString {
+		l.Warnf(ErrorEmptyOverrideService)
+		return "", false
+	}
+	if override.Content == EmptyString {
+		l.Warnf(ErrorEmptyOverrideContent, override.Service)
+		return "", false
+	}
+	// Override name is optional, default to override-yip.conf
+	if override.Name == EmptyString {
+		override.Name = DefaultOverrideName
+	}
+	// Ensure the extension is .conf
+	if filepath.Ext(override.Name) != DefaultOverrideExt {
+		override.Name = override.Name + DefaultOverrideExt
+	}
+	// Ensure the service has a .service extension
+	if filepath.Ext(override.Service) != DefaultServiceExt {
+		override.Service = override.Service + DefaultServiceExt
+	}
+	return filepath.Join(fmt.Sprintf(DefaultOverrideDir, override.Service), override.Name), true
+}
@@ -12,19 +12,10 @@ import (
 
 func SystemdFirstboot(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var err error
-	var args []string
 	var out string
 
-	for k, v := range s.SystemdFirstBoot {
-		if v == "true" {
-			args = append(args, fmt.Sprintf("--%s", strings.ToLower(k)))
-		} else {
-			args = append(args, fmt.Sprintf("--%s=%s", strings.ToLower(k), v))
-		}
-	}
-
+	args := firstbootArgs(s)
 	if len(args) > 0 {
-		sort.Strings(args)
 		arguments := strings.Join(args, " ")
 		l.Debugf("running 'systemd-firstboot' with arguments: %s", arguments)
 		out, err = console.Run(fmt.Sprintf("systemd-firstboot %s", arguments))
@@ -33,3 +24,17 @@ func SystemdFirstboot(l logger.Interface, s schema.Stage, fs vfs.FS, console Con
 
 	return err
 }
+
+// firstbootArgs returns the sorted systemd-firstboot arguments for the stage
+func firstbootArgs(s schema.Stage) []string {
+	var args []string
+	for _, k := range sortedKeys(s.SystemdFirstBoot) {
+		if v := s.SystemdFirstBoot[k]; v == "true" {
+			args = append(args, fmt.Sprintf("--%s", strings.ToLower(k)))
+		} else {
+			args = append(args, fmt.Sprintf("--%s=%s", strings.ToLower(k), v))
+		}
+	}
+	sort.Strings(args)
+	return args
+}
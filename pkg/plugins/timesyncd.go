@@ -39,8 +39,8 @@ func Timesyncd(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) e
 		return err
 	}
 
-	for k, v := range s.TimeSyncd {
-		cfg.Section("Time").Key(k).SetValue(v)
+	for _, k := range sortedKeys(s.TimeSyncd) {
+		cfg.Section("Time").Key(k).SetValue(s.TimeSyncd[k])
 	}
 
 	cfg.SaveTo(path)
@@ -51,5 +51,20 @@ var _ = Describe("Timesyncd", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(string(b)).Should(Equal("[Time]\nNTP = 0.pool\n"))
 		})
+
+		It("writes the keys in lexicographic order", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/systemd/foo.conf": ""})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = Timesyncd(l, schema.Stage{
+				TimeSyncd: map[string]string{"RootDistanceMaxSec": "5", "NTP": "0.pool", "FallbackNTP": "1.pool", "PollIntervalMinSec": "32"},
+			}, fs, &testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			b, err := fs.ReadFile("/etc/systemd/timesyncd.conf.d/10-yip.conf")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("[Time]\nFallbackNTP        = 1.pool\nNTP                = 0.pool\nPollIntervalMinSec = 32\nRootDistanceMaxSec = 5\n"))
+		})
 	})
 })
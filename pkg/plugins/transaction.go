@@ -0,0 +1,120 @@
+package plugins
+
+import (
+	"os"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+// Backup holds the previous content and mode of the files written by a stage,
+// so they can be restored if the stage fails.
+type Backup struct {
+	files []backupFile
+}
+
+type backupFile struct {
+	fs      vfs.FS
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+}
+
+// BackupFiles records the current state of the files that the stage would write
+// with the files, environment, dns, timesyncd, systemctl overrides and hostname plugins.
+func BackupFiles(l logger.Interface, s schema.Stage, fs vfs.FS) (*Backup, error) {
+	b := &Backup{}
+	for _, t := range transactionFiles(s, fs) {
+		f := backupFile{fs: t.fs, path: t.path}
+		info, err := t.fs.Stat(t.path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			f.content, err = t.fs.ReadFile(t.path)
+			if err != nil {
+				return nil, err
+			}
+			f.existed = true
+			f.mode = info.Mode().Perm()
+		}
+		l.Debugf("Backed up %s (existing: %t)", t.path, f.existed)
+		b.files = append(b.files, f)
+	}
+	return b, nil
+}
+
+// Paths returns the files recorded in the backup
+func (b *Backup) Paths() []string {
+	res := []string{}
+	for _, f := range b.files {
+		res = append(res, f.path)
+	}
+	return res
+}
+
+// Restore brings back the files to their previous content and mode.
+// Files which didn't exist when the backup was taken are removed.
+func (b *Backup) Restore() error {
+	var errs error
+	for _, f := range b.files {
+		if !f.existed {
+			if err := f.fs.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				errs = multierror.Append(errs, err)
+			}
+			continue
+		}
+		if err := f.fs.WriteFile(f.path, f.content, f.mode); err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if err := f.fs.Chmod(f.path, f.mode); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+type transactionFile struct {
+	fs   vfs.FS
+	path string
+}
+
+// transactionFiles returns the files written by the plugins for the stage
+func transactionFiles(s schema.Stage, fs vfs.FS) []transactionFile {
+	var res []transactionFile
+	for _, f := range s.Files {
+		res = append(res, transactionFile{fs, f.Path})
+	}
+	if len(s.Environment) > 0 {
+		environment := s.EnvironmentFile
+		if environment == "" {
+			environment = environmentFile
+		}
+		res = append(res, transactionFile{fs, environment})
+	}
+	if len(s.Dns.Nameservers) > 0 {
+		// DNS writes directly on the host, ignoring fs
+		res = append(res, transactionFile{vfs.OSFS, dnsPath(s)})
+	}
+	if len(s.TimeSyncd) > 0 {
+		res = append(res, transactionFile{fs, timeSyncd})
+	}
+	for _, o := range s.Systemctl.Overrides {
+		// Skipped overrides are not written, no need to go through overridePath and its warnings
+		if o.Service == EmptyString || o.Content == EmptyString {
+			continue
+		}
+		if path, ok := overridePath(nil, o); ok {
+			res = append(res, transactionFile{fs, path})
+		}
+	}
+	if s.Hostname != "" {
+		res = append(res, transactionFile{fs, "/etc/hosts"}, transactionFile{fs, "/etc/hostname"})
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package plugins_test
+
+import (
+	"io"
+	"os"
+
+	. "github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	consoletests "github.com/mudler/yip/tests/console"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v5/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BackupFiles", func() {
+	testConsole := consoletests.TestConsole{}
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	It("restores the files written by the plugins", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+			"/etc/environment": &vfst.File{Contents: []byte("foo=bar\n"), Perm: 0600},
+			"/etc/systemd":     &vfst.Dir{Perm: 0755},
+		})
+		Expect(err).Should(BeNil())
+		defer cleanup()
+
+		stage := schema.Stage{
+			Environment: map[string]string{"foo": "baz"},
+			TimeSyncd:   map[string]string{"NTP": "0.pool"},
+			Systemctl: schema.Systemctl{Overrides: []schema.SystemctlOverride{
+				{Service: "foo", Content: "[Service]\n"},
+				{Service: "skipped"},
+			}},
+		}
+
+		backup, err := BackupFiles(l, stage, fs)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(backup.Paths()).To(Equal([]string{
+			"/etc/environment",
+			"/etc/systemd/timesyncd.conf.d/10-yip.conf",
+			"/etc/systemd/system/foo.service.d/override-yip.conf",
+		}))
+
+		Expect(Environment(l, stage, fs, &testConsole)).To(Succeed())
+		Expect(Timesyncd(l, stage, fs, &testConsole)).To(Succeed())
+		Expect(Systemctl(l, stage, fs, &testConsole)).To(Succeed())
+
+		Expect(backup.Restore()).To(Succeed())
+
+		b, err := fs.ReadFile("/etc/environment")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(b)).To(Equal("foo=bar\n"))
+		info, err := fs.Stat("/etc/environment")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		for _, f := range []string{"/etc/systemd/timesyncd.conf.d/10-yip.conf", "/etc/systemd/system/foo.service.d/override-yip.conf"} {
+			_, err = fs.Stat(f)
+			Expect(os.IsNotExist(err)).To(BeTrue(), f)
+		}
+	})
+})
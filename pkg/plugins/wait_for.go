@@ -0,0 +1,97 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mudler/yip/pkg/logger"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+const (
+	defaultWaitForTimeout  = 60
+	defaultWaitForInterval = 1
+)
+
+// WaitFor blocks until all the conditions of the wait_for block of the stage are met,
+// checking them every interval. It fails when the timeout expires or the context is cancelled.
+func WaitFor(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	w := s.WaitFor
+	if w.IsZero() {
+		return nil
+	}
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = defaultWaitForTimeout
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultWaitForInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	console = ConsoleWithContext(ctx, console)
+
+	for attempt := 1; ; attempt++ {
+		err := checkWaitFor(ctx, l, w, fs, console, time.Duration(interval)*time.Second)
+		if err == nil {
+			return nil
+		}
+		if attempt == 1 {
+			l.Infof("Waiting for %s", err)
+		} else {
+			l.Debugf("Still waiting for %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %ds waiting for %s", timeout, err)
+			}
+			return fmt.Errorf("interrupted while waiting for %s: %w", err, ctx.Err())
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+	}
+}
+
+// checkWaitFor returns an error describing the first condition which isn't met
+func checkWaitFor(ctx context.Context, l logger.Interface, w schema.WaitFor, fs vfs.FS, console Console, dialTimeout time.Duration) error {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	if w.File != "" {
+		if _, err := fs.Stat(w.File); err != nil {
+			return fmt.Errorf("file %s: %w", w.File, err)
+		}
+	}
+	if w.UnixSocket != "" {
+		path, err := fs.RawPath(w.UnixSocket)
+		if err != nil {
+			return fmt.Errorf("unix socket %s: %w", w.UnixSocket, err)
+		}
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		if err != nil {
+			return fmt.Errorf("unix socket %s: %w", w.UnixSocket, err)
+		}
+		conn.Close()
+	}
+	if w.TCP != "" {
+		conn, err := dialer.DialContext(ctx, "tcp", w.TCP)
+		if err != nil {
+			return fmt.Errorf("tcp %s: %w", w.TCP, err)
+		}
+		conn.Close()
+	}
+	if w.Command != "" {
+		if out, err := console.Run(templateSysData(l, w.Command)); err != nil {
+			if strings.TrimSpace(out) != "" {
+				return fmt.Errorf("command '%s': %w\ncommand output:\n%s", w.Command, err, out)
+			}
+			return fmt.Errorf("command '%s': %w", w.Command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package plugins_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/yip/pkg/console"
+	. "github.com/mudler/yip/pkg/plugins"
+	"github.com/mudler/yip/pkg/schema"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/v5"
+	"github.com/twpayne/go-vfs/v5/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WaitFor", func() {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	testConsole := console.NewStandardConsole()
+
+	It("does nothing without conditions", func() {
+		Expect(WaitFor(context.Background(), l, schema.Stage{}, vfs.OSFS, testConsole)).To(Succeed())
+	})
+
+	It("waits for a file and a command", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/dev": &vfst.Dir{Perm: 0755}})
+		Expect(err).Should(BeNil())
+		defer cleanup()
+		raw, err := fs.RawPath("/dev/ready")
+		Expect(err).Should(BeNil())
+
+		go func() {
+			time.Sleep(1500 * time.Millisecond)
+			_ = os.WriteFile(raw, []byte{}, 0644)
+		}()
+
+		start := time.Now()
+		Expect(WaitFor(context.Background(), l, schema.Stage{WaitFor: schema.WaitFor{
+			File:    "/dev/ready",
+			Command: "test -e " + raw,
+			Timeout: 10,
+		}}, fs, testConsole)).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+	})
+
+	It("waits for a tcp port and a unix socket", func() {
+		tcp, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer tcp.Close()
+
+		dir, err := os.MkdirTemp("", "yip-wait-for")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		unix, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+		Expect(err).ToNot(HaveOccurred())
+		defer unix.Close()
+
+		Expect(WaitFor(context.Background(), l, schema.Stage{WaitFor: schema.WaitFor{
+			TCP:        tcp.Addr().String(),
+			UnixSocket: filepath.Join(dir, "agent.sock"),
+			Timeout:    5,
+		}}, vfs.OSFS, testConsole)).To(Succeed())
+	})
+
+	It("fails when the timeout expires", func() {
+		err := WaitFor(context.Background(), l, schema.Stage{WaitFor: schema.WaitFor{
+			File:    "/does/not/exist",
+			Timeout: 1,
+		}}, vfs.OSFS, testConsole)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("timed out after 1s waiting for file /does/not/exist"))
+	})
+
+	It("fails when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := WaitFor(ctx, l, schema.Stage{WaitFor: schema.WaitFor{Command: "false"}}, vfs.OSFS, testConsole)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("interrupted"))
+	})
+})
@@ -4,6 +4,7 @@
 package config
 
 import (
+	"fmt"
 	"strings"
 	"unicode"
 
@@ -48,11 +49,32 @@ func IsCloudConfig(userdata string) bool {
 // directly to YAML. Fields that cannot be set in the cloud-config (fields
 // used for internal use) have the YAML tag '-' so that they aren't marshalled.
 type CloudConfig struct {
-	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
-	WriteFiles        []File   `yaml:"write_files,omitempty"`
-	Hostname          string   `yaml:"hostname,omitempty"`
-	Users             []User   `yaml:"users,omitempty"`
-	RunCmd            []string `yaml:"runcmd,omitempty"`
+	SSHAuthorizedKeys []string  `yaml:"ssh_authorized_keys,omitempty"`
+	WriteFiles        []File    `yaml:"write_files,omitempty"`
+	Hostname          string    `yaml:"hostname,omitempty"`
+	Users             []User    `yaml:"users,omitempty"`
+	RunCmd            []Command `yaml:"runcmd,omitempty"`
+	BootCmd           []Command `yaml:"bootcmd,omitempty"`
+
+	Packages                []Package `yaml:"packages,omitempty"`
+	PackageUpdate           bool      `yaml:"package_update,omitempty"`
+	PackageUpgrade          bool      `yaml:"package_upgrade,omitempty"`
+	PackageRebootIfRequired bool      `yaml:"package_reboot_if_required,omitempty"`
+	// Deprecated names of the package options, still accepted by cloud-init
+	AptUpdate           bool `yaml:"apt_update,omitempty"`
+	AptUpgrade          bool `yaml:"apt_upgrade,omitempty"`
+	AptRebootIfRequired bool `yaml:"apt_reboot_if_required,omitempty"`
+
+	// Mounts are fstab entries: [device, mount point, type, options, dump, pass].
+	// Missing fields are taken from MountDefaultFields.
+	Mounts             [][]*string `yaml:"mounts,omitempty"`
+	MountDefaultFields []*string   `yaml:"mount_default_fields,omitempty"`
+	Swap               Swap        `yaml:"swap,omitempty"`
+
+	Timezone string   `yaml:"timezone,omitempty"`
+	Locale   string   `yaml:"locale,omitempty"`
+	NTP      *NTP     `yaml:"ntp,omitempty"`
+	Keyboard Keyboard `yaml:"keyboard,omitempty"`
 
 	Partitioning GrowPart `yaml:"growpart"`
 	// this one is legacy, can be removed when no more kip controllers use it
@@ -60,6 +82,101 @@ type CloudConfig struct {
 	// Todo: add additional parameters supported by traditional cloud-init
 }
 
+// Command is a command to run, given either as a string run by the shell or as a list
+// of arguments. The arguments are quoted, so they are passed as is to the command.
+type Command string
+
+func (c *Command) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode((*string)(c))
+	case yaml.SequenceNode:
+		var args []string
+		if err := value.Decode(&args); err != nil {
+			return err
+		}
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = ShellQuote(a)
+		}
+		*c = Command(strings.Join(quoted, " "))
+		return nil
+	default:
+		return fmt.Errorf("line %d: commands must be a string or a list of arguments", value.Line)
+	}
+}
+
+// ShellQuote quotes the argument for the shell, unless it's made only of safe characters
+func ShellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@%+=:,./_-", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// Package is a package to install, either a name or a [name, version] list
+type Package struct {
+	Name    string
+	Version string
+}
+
+func (p *Package) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&p.Name)
+	case yaml.SequenceNode:
+		var l []string
+		if err := value.Decode(&l); err != nil {
+			return err
+		}
+		if len(l) == 0 || len(l) > 2 {
+			return fmt.Errorf("line %d: packages must be a name or a [name, version] list", value.Line)
+		}
+		p.Name = l[0]
+		if len(l) == 2 {
+			p.Version = l[1]
+		}
+		return nil
+	default:
+		return fmt.Errorf("line %d: packages must be a name or a [name, version] list", value.Line)
+	}
+}
+
+// String returns the package as name=version, or name if no version is given
+func (p Package) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + "=" + p.Version
+}
+
+// Swap is a swap file to create. Sizes are in bytes, with an optional
+// K, M, G or T suffix, and Size can be "auto" to size it after the memory.
+type Swap struct {
+	Filename string `yaml:"filename,omitempty"`
+	Size     string `yaml:"size,omitempty"`
+	MaxSize  string `yaml:"maxsize,omitempty"`
+}
+
+// NTP configures the time synchronization. Enabled defaults to true when
+// the ntp key is given, and the default servers are used if none is.
+type NTP struct {
+	Enabled   *bool    `yaml:"enabled,omitempty"`
+	NTPClient string   `yaml:"ntp_client,omitempty"`
+	Servers   []string `yaml:"servers,omitempty"`
+	Pools     []string `yaml:"pools,omitempty"`
+}
+
+// Keyboard is the keyboard configuration. Only the layout is required.
+type Keyboard struct {
+	Layout  string `yaml:"layout,omitempty"`
+	Model   string `yaml:"model,omitempty"`
+	Variant string `yaml:"variant,omitempty"`
+	Options string `yaml:"options,omitempty"`
+}
+
 type GrowPart struct {
 	Mode    string   `yaml:"mode,omitempty"`
 	Devices []string `yaml:"devices"`
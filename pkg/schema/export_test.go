@@ -0,0 +1,28 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package schema
+
+import "gopkg.in/yaml.v3"
+
+// AddMigration adds a migration to the version after CurrentVersion, as a change
+// to the format would, returning a function removing it.
+func AddMigration(migrate func(root *yaml.Node) bool) func() {
+	saved := migrations
+	migrations = append(append([]migration{}, migrations...), migration{version: CurrentVersion + 1, migrate: migrate})
+	currentVersion = CurrentVersion + 1
+	return func() {
+		migrations, currentVersion = saved, CurrentVersion
+	}
+}
@@ -0,0 +1,182 @@
+package schema
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mudler/yip/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs/v5"
+)
+
+// Includes loads yip configs along with the configs they include. Every config is
+// loaded only once, so configs included by several ones, or both included and loaded
+// directly, are not applied more than once.
+type Includes struct {
+	fs       vfs.FS
+	modifier Modifier
+	options  loadOptions
+	// stack holds the sources being loaded, to detect cycles
+	stack []string
+	// loaded holds the sources already loaded, which are not loaded again
+	loaded map[string]bool
+}
+
+// NewIncludes returns an Includes loading the configs from fs, with the given
+// modifier and options.
+func NewIncludes(fs vfs.FS, m Modifier, opts ...LoadOption) *Includes {
+	options := loadOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
+	if m == nil {
+		m = func(b []byte) ([]byte, error) { return b, nil }
+	}
+	return &Includes{fs: fs, modifier: m, options: options, loaded: map[string]bool{}}
+}
+
+// Load loads the config from s with l and the configs it includes, recursively. The
+// included configs are returned before the including one, in the order of the include
+// lists, each with its own source. Configs loaded already by a previous call are not
+// returned again, so nothing is returned if s itself was loaded already. A nil Loader
+// loads s as the content of a config.
+func (i *Includes) Load(s string, l Loader) ([]*YipConfig, error) {
+	// Configs given as content have no source to resolve relative includes from
+	id := filepath.Clean(s)
+	if utils.IsUrl(s) {
+		id = s
+	}
+	if l == nil {
+		id = ""
+		l = func(c string, fs vfs.FS, m Modifier) ([]byte, error) { return m([]byte(c)) }
+	}
+	return i.load(s, id, l)
+}
+
+func (i *Includes) load(s, id string, l Loader) ([]*YipConfig, error) {
+	if id != "" {
+		if i.loaded[id] {
+			return nil, nil
+		}
+		i.loaded[id] = true
+	}
+
+	data, err := l(s, i.fs, i.modifier)
+	if err != nil {
+		return nil, errors.Wrap(err, "while loading yipconfig")
+	}
+
+	loader, err := detect(data, i.options)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid file type")
+	}
+	config, err := loader.Load(s, data, i.fs)
+	if err != nil {
+		return nil, err
+	}
+	if id != "" && config.Source == "" {
+		config.Source = s
+	}
+	if len(config.Include) == 0 || i.options.skipIncludes {
+		return []*YipConfig{config}, nil
+	}
+
+	if id != "" {
+		i.stack = append(i.stack, id)
+		defer func() { i.stack = i.stack[:len(i.stack)-1] }()
+	}
+
+	configs := []*YipConfig{}
+	for _, inc := range config.Include {
+		sources, err := i.resolve(id, inc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while resolving include '%s'", inc)
+		}
+		for _, src := range sources {
+			for _, p := range i.stack {
+				if p == src {
+					return nil, fmt.Errorf("include cycle: %s", strings.Join(append(i.stack, src), " -> "))
+				}
+			}
+
+			from := FromFile
+			if utils.IsUrl(src) {
+				from = FromUrl
+			}
+			included, err := i.load(src, src, from)
+			if err != nil {
+				return nil, errors.Wrapf(err, "while including '%s'", src)
+			}
+			configs = append(configs, included...)
+		}
+	}
+	// Includes are resolved, the config is self-contained from now on
+	config.Include = nil
+
+	return append(configs, config), nil
+}
+
+// resolve returns the sources an include of the given source refers to.
+// Relative paths are relative to the including source, directories are expanded to
+// the yaml files they contain and globs to the files matching them, in lexicographic order.
+func (i *Includes) resolve(id, inc string) ([]string, error) {
+	if utils.IsUrl(inc) {
+		return []string{inc}, nil
+	}
+	if utils.IsUrl(id) {
+		base, err := url.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := base.Parse(inc)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ref.String()}, nil
+	}
+
+	path := filepath.Clean(inc)
+	if !filepath.IsAbs(path) && id != "" {
+		path = filepath.Join(filepath.Dir(id), path)
+	}
+
+	paths := []string{path}
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := i.fs.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		paths = matches
+	}
+
+	res := []string{}
+	for _, p := range paths {
+		info, err := i.fs.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			res = append(res, p)
+			continue
+		}
+		err = vfs.Walk(i.fs, p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(path)
+			if !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				res = append(res, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONSchema is the subset of JSON Schema needed to describe yip configs
+type JSONSchema struct {
+	Schema      string   `json:"$schema,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	// AdditionalProperties is false for structs, and the schema of the values for maps
+	AdditionalProperties interface{}   `json:"additionalProperties,omitempty"`
+	PropertyNames        *JSONSchema   `json:"propertyNames,omitempty"`
+	Items                *JSONSchema   `json:"items,omitempty"`
+	AnyOf                []*JSONSchema `json:"anyOf,omitempty"`
+}
+
+// enums are the values allowed for the string types with a fixed set of values
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(IfCheckAny):      {string(IfCheckAny), string(IfCheckAll), string(IfCheckNone)},
+	reflect.TypeOf(FrequencyAlways): {string(FrequencyAlways), string(FrequencyOnce), string(FrequencyPerInstance)},
+	reflect.TypeOf(FailureAbort):    {string(FailureAbort), string(FailureContinue), string(FailureIgnore)},
+}
+
+// YipJSONSchema returns the JSON Schema of the yip configs, generated from YipConfig
+func YipJSONSchema() *JSONSchema {
+	s := typeSchema(reflect.TypeOf(YipConfig{}))
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = "yip config"
+	return s
+}
+
+func typeSchema(t reflect.Type) *JSONSchema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// on_failure is either a policy, a list of commands or a map with both
+	if t == reflect.TypeOf(FailureHandler{}) {
+		return &JSONSchema{AnyOf: []*JSONSchema{
+			typeSchema(reflect.TypeOf(FailureAbort)),
+			typeSchema(reflect.TypeOf([]string{})),
+			structSchema(t),
+		}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string", Enum: enums[t]}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		s := &JSONSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+		if keys := enums[t.Key()]; keys != nil {
+			s.PropertyNames = &JSONSchema{Type: "string", Enum: keys}
+		}
+		return s
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		// Anything goes
+		return &JSONSchema{}
+	}
+}
+
+func structSchema(t reflect.Type) *JSONSchema {
+	s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			// Same default as the yaml library
+			name = strings.ToLower(f.Name)
+		}
+		s.Properties[name] = typeSchema(f.Type)
+	}
+	return s
+}
@@ -107,16 +107,38 @@ func (cloudInit) Load(source string, s []byte, fs vfs.FS) (*YipConfig, error) {
 		}
 	}
 	stages := []Stage{{
-		Commands: cc.RunCmd,
+		Commands: commands(cc.RunCmd),
 		Files:    f,
 		Users:    users,
 		SSHKeys:  noNetworkSshKeys,
 	}}
 
-	networkSshKeysStage := []Stage{{
+	// Mounts come first, so the files and commands of the boot stage can use them
+	mounts, err := mountCommands(cc)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) > 0 {
+		stages = append([]Stage{{Commands: mounts}}, stages...)
+	}
+
+	networkStage := []Stage{{
 		SSHKeys: networkSshKeys,
 	}}
 
+	// Packages are installed from the repositories, so they need network too
+	packages := Packages{
+		Refresh:          cc.PackageUpdate || cc.AptUpdate || cc.PackageUpgrade || cc.AptUpgrade,
+		Upgrade:          cc.PackageUpgrade || cc.AptUpgrade,
+		RebootIfRequired: cc.PackageRebootIfRequired || cc.AptRebootIfRequired,
+	}
+	for _, p := range cc.Packages {
+		packages.Install = append(packages.Install, p.String())
+	}
+	if packages.Refresh || len(packages.Install) > 0 {
+		networkStage = append(networkStage, Stage{Packages: packages})
+	}
+
 	for _, d := range cc.Partitioning.Devices {
 		layout := &Layout{}
 		layout.Expand = &Expand{Size: 0}
@@ -124,12 +146,16 @@ func (cloudInit) Load(source string, s []byte, fs vfs.FS) (*YipConfig, error) {
 		stages = append(stages, Stage{Layout: *layout})
 	}
 
+	// The system settings are applied early, before the services using them start
+	initramfs := systemStage(cc)
+	initramfs.Hostname = cc.Hostname
+	// bootcmd runs early, before the boot stage where runcmd runs
+	initramfs.Commands = commands(cc.BootCmd)
+
 	finalStages := map[string][]Stage{
-		"boot": stages,
-		"initramfs": {{
-			Hostname: cc.Hostname,
-		}},
-		"network": networkSshKeysStage,
+		"boot":      stages,
+		"initramfs": {initramfs},
+		"network":   networkStage,
 	}
 
 	result := &YipConfig{Stages: finalStages}
@@ -145,6 +171,14 @@ func (cloudInit) Load(source string, s []byte, fs vfs.FS) (*YipConfig, error) {
 	return result, nil
 }
 
+func commands(cmds []cloudconfig.Command) []string {
+	var res []string
+	for _, c := range cmds {
+		res = append(res, string(c))
+	}
+	return res
+}
+
 func parseOctal(srv string) (uint32, error) {
 	if srv == "" {
 		return 0, nil
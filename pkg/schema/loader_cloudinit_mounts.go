@@ -0,0 +1,135 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	cloudconfig "github.com/mudler/yip/pkg/schema/cloudinit"
+)
+
+// fstabComment marks the fstab entries managed by the cloud-config mounts,
+// like cloud-init does, so they are replaced rather than duplicated on every run
+const fstabComment = "comment=cloudconfig"
+
+// defaultMountFields are the fields of the mount entries missing in the cloud-config
+var defaultMountFields = []string{"", "", "auto", "defaults,nofail", "0", "2"}
+
+const (
+	defaultSwapFile    = "/swap.img"
+	defaultSwapMaxSize = 8 << 30
+)
+
+// mountCommands returns the commands creating the swap file and the mount points,
+// replacing the cloud-config entries of /etc/fstab and mounting them.
+func mountCommands(cc *cloudconfig.CloudConfig) ([]string, error) {
+	defaults := append([]string{}, defaultMountFields...)
+	for i, f := range cc.MountDefaultFields {
+		if i < len(defaults) && f != nil {
+			defaults[i] = *f
+		}
+	}
+
+	var cmds, entries []string
+	swap := false
+	for _, m := range cc.Mounts {
+		entry := append([]string{}, defaults...)
+		for i, f := range m {
+			if i < len(entry) && f != nil {
+				entry[i] = *f
+			}
+		}
+		// Entries without mount point remove the device from fstab in cloud-init,
+		// here they are just not added again
+		if entry[0] == "" || entry[1] == "" {
+			continue
+		}
+		entry[0] = mountDevice(entry[0])
+		entry[3] += "," + fstabComment
+		if entry[2] == "swap" {
+			swap = true
+		} else if strings.HasPrefix(entry[1], "/") {
+			cmds = append(cmds, "mkdir -p "+cloudconfig.ShellQuote(entry[1]))
+		}
+		entries = append(entries, strings.Join(entry, "\t"))
+	}
+
+	if cc.Swap.Size != "" && cc.Swap.Size != "0" {
+		file := cc.Swap.Filename
+		if file == "" {
+			file = defaultSwapFile
+		}
+		cmd, err := swapFileCommand(file, cc.Swap.Size, cc.Swap.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+		entries = append(entries, strings.Join([]string{file, "none", "swap", "sw," + fstabComment, "0", "0"}, "\t"))
+		swap = true
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	cmds = append(cmds, fmt.Sprintf("touch /etc/fstab && sed -i '/%s/d' /etc/fstab", fstabComment))
+	quoted := []string{}
+	for _, e := range entries {
+		quoted = append(quoted, cloudconfig.ShellQuote(e))
+	}
+	cmds = append(cmds, fmt.Sprintf("printf '%%s\\n' %s >> /etc/fstab", strings.Join(quoted, " ")))
+	if swap {
+		cmds = append(cmds, "swapon -a")
+	}
+	return append(cmds, "mount -a"), nil
+}
+
+// mountDevice returns the path of the device, as cloud-init accepts short names like sdb
+func mountDevice(dev string) string {
+	if strings.HasPrefix(dev, "/") || strings.Contains(dev, "=") || strings.Contains(dev, ":") || dev == "none" || dev == "swap" {
+		return dev
+	}
+	return "/dev/" + dev
+}
+
+// swapFileCommand returns the command creating the swap file, if it doesn't exist.
+// The "auto" size follows cloud-init: the memory size below 4G, 4G below 16G
+// of memory and the square root of the memory in G above, up to maxSize.
+func swapFileCommand(file, size, maxSize string) (string, error) {
+	max := int64(defaultSwapMaxSize)
+	if maxSize != "" {
+		var err error
+		if max, err = parseSize(maxSize); err != nil {
+			return "", fmt.Errorf("invalid swap maxsize: %w", err)
+		}
+	}
+
+	sizeExpr := ""
+	if size == "auto" {
+		sizeExpr = fmt.Sprintf(`$(awk '/MemTotal/ {m=$2*1024; g=1073741824; if (m<4*g) s=m; else if (m<16*g) s=4*g; else s=int(sqrt(m/g)+0.5)*g; if (s>%d) s=%d; printf "%%.0f", s}' /proc/meminfo)`, max, max)
+	} else {
+		bytes, err := parseSize(size)
+		if err != nil {
+			return "", fmt.Errorf("invalid swap size: %w", err)
+		}
+		sizeExpr = strconv.FormatInt(bytes, 10)
+	}
+
+	f := cloudconfig.ShellQuote(file)
+	return fmt.Sprintf(`[ -e %[1]s ] || { size=%[2]s; { fallocate -l "$size" %[1]s || dd if=/dev/zero of=%[1]s bs=1M count=$((size / 1048576)); } && chmod 600 %[1]s && mkswap %[1]s; }`, f, sizeExpr), nil
+}
+
+// parseSize parses a size in bytes, with an optional K, M, G or T suffix (powers of 1024)
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		mult = int64(1) << (10 * (strings.IndexByte("KMGT", s[i]) + 1))
+		s = s[:i]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return int64(f * float64(mult)), nil
+}
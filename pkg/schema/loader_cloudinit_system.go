@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"strings"
+
+	cloudconfig "github.com/mudler/yip/pkg/schema/cloudinit"
+)
+
+const timesyncdService = "systemd-timesyncd"
+
+// systemStage returns the step setting the timezone, the locale, the keymap
+// and the NTP servers of the cloud-config
+func systemStage(cc *cloudconfig.CloudConfig) Stage {
+	s := Stage{}
+
+	firstBoot := map[string]string{}
+	if cc.Timezone != "" {
+		firstBoot["timezone"] = cc.Timezone
+	}
+	if cc.Locale != "" {
+		firstBoot["locale"] = cc.Locale
+	}
+	if keymap := keymap(cc.Keyboard); keymap != "" {
+		firstBoot["keymap"] = keymap
+	}
+	if len(firstBoot) > 0 {
+		// cloud-init sets them even if the system already has them
+		firstBoot["force"] = "true"
+		s.SystemdFirstBoot = firstBoot
+	}
+
+	if ntp := cc.NTP; ntp != nil && (ntp.Enabled == nil || *ntp.Enabled) {
+		// Only systemd-timesyncd is supported, other clients are left to the user
+		if ntp.NTPClient == "" || ntp.NTPClient == "auto" || ntp.NTPClient == timesyncdService {
+			if servers := append(append([]string{}, ntp.Servers...), ntp.Pools...); len(servers) > 0 {
+				s.TimeSyncd = map[string]string{"NTP": strings.Join(servers, " ")}
+			}
+			s.Systemctl.Enable = []string{timesyncdService}
+		}
+	}
+
+	return s
+}
+
+// keymap returns the console keymap of the keyboard, as layout-variant.
+// The model and the options only apply to X11, and are ignored.
+func keymap(k cloudconfig.Keyboard) string {
+	if k.Layout == "" || k.Variant == "" {
+		return k.Layout
+	}
+	return k.Layout + "-" + k.Variant
+}
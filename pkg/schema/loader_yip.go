@@ -1,19 +1,61 @@
 package schema
 
 import (
+	"fmt"
+
 	"github.com/twpayne/go-vfs/v5"
 	"gopkg.in/yaml.v3"
 )
 
-type yipYAML struct{}
+type yipYAML struct {
+	options loadOptions
+}
 
-// LoadFromYaml loads a yip config from bytes
-func (yipYAML) Load(source string, b []byte, fs vfs.FS) (*YipConfig, error) {
+// LoadFromYaml loads a yip config from bytes. The config is migrated to CurrentVersion
+// and decoded from its nodes, which keep the positions of the original yaml.
+func (y yipYAML) Load(source string, b []byte, fs vfs.FS) (*YipConfig, error) {
 	var yamlConfig YipConfig
-	err := yaml.Unmarshal(b, &yamlConfig)
+	doc, err := parseDocument(b)
 	if err != nil {
 		return nil, err
 	}
+	// Empty documents are empty configs
+	if doc != nil {
+		if _, err := migrate(doc); err != nil {
+			return nil, err
+		}
+		if err := doc.Decode(&yamlConfig); err != nil {
+			return nil, err
+		}
+		if err := y.checkUnknownFields(source, doc); err != nil {
+			return nil, err
+		}
+	}
 	yamlConfig.Source = source
 	return &yamlConfig, nil
 }
+
+// checkUnknownFields reports the unknown fields of the config, as an error in strict
+// mode or as warnings. They are ignored otherwise.
+func (y yipYAML) checkUnknownFields(source string, doc *yaml.Node) error {
+	if !y.options.strict && y.options.warn == nil {
+		return nil
+	}
+	errs := []string{}
+	for _, e := range unknownFields(doc) {
+		in := "config"
+		if e.path != "" {
+			in = e.path
+		}
+		msg := fmt.Sprintf("line %d: field %s not found in %s", e.Line, e.unknownField, in)
+		if y.options.strict {
+			errs = append(errs, msg)
+			continue
+		}
+		y.options.warn(source, msg)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &yaml.TypeError{Errors: errs}
+}
@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	config "github.com/mudler/yip/pkg/schema/cloudinit"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the version of the yip config format described by YipConfig.
+// Configs with an older version are migrated when loaded.
+const CurrentVersion = 1
+
+// unversioned is the version of the configs without one, which is the format
+// yip accepted before versions were introduced
+const unversioned = 1
+
+// currentVersion is the version the configs are migrated to, which is CurrentVersion
+// unless tests add migrations to a newer one
+var currentVersion = CurrentVersion
+
+// migration upgrades the top level node of a document from the previous version
+// to version, returning whether anything changed
+type migration struct {
+	version int
+	migrate func(root *yaml.Node) bool
+}
+
+// migrations are applied in order to the documents with an older version. Every
+// change to the format which breaks the configs of the previous version, e.g. a
+// renamed key, bumps CurrentVersion and adds the migration to it here.
+var migrations = []migration{}
+
+// Migrate upgrades a yip config in yaml to CurrentVersion, setting its version.
+// It returns the migrated yaml, and false if no migration changed the config, in
+// which case it's returned as is. Comments are kept, but the yaml is reformatted if
+// anything changed. Cloud-config content is returned as is.
+func Migrate(b []byte) ([]byte, bool, error) {
+	if config.IsCloudConfig(string(b)) {
+		return b, false, nil
+	}
+	doc, err := parseDocument(b)
+	if err != nil || doc == nil || doc.Content[0].Kind != yaml.MappingNode {
+		return b, false, err
+	}
+	changed, err := migrate(doc)
+	if err != nil || !changed {
+		return b, false, err
+	}
+	res, err := encodeDocument(doc)
+	return res, true, err
+}
+
+// migrate applies the migrations for the version of the document, returning
+// whether it changed. The version of a changed document is set to CurrentVersion.
+func migrate(doc *yaml.Node) (bool, error) {
+	root := doc.Content[0]
+	version := unversioned
+	if v, ok := versionNode(root); ok {
+		var err error
+		if version, err = strconv.Atoi(v.Value); err != nil {
+			return false, fmt.Errorf("line %d: invalid version '%s'", v.Line, v.Value)
+		}
+	}
+	if version > currentVersion {
+		return false, fmt.Errorf("unsupported version %d, the latest supported version is %d", version, currentVersion)
+	}
+
+	changed := false
+	for _, m := range migrations {
+		if m.version > version && m.migrate(root) {
+			changed = true
+		}
+	}
+	if changed {
+		setVersion(root)
+	}
+	return changed, nil
+}
+
+// mappingValue returns the value of the key in the mapping node, or nil
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func versionNode(root *yaml.Node) (*yaml.Node, bool) {
+	v := mappingValue(root, "version")
+	return v, v != nil
+}
+
+// setVersion sets the version to CurrentVersion, adding it as first key if missing
+func setVersion(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(currentVersion)}
+	if v, ok := versionNode(root); ok {
+		*v = *value
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	// Comments at the top of the document stay there
+	if len(root.Content) > 0 {
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// parseDocument returns the document node of the yaml, or nil if it's empty.
+// The document node holds the comments at the top of the yaml.
+func parseDocument(b []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(b)).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+func encodeDocument(n *yaml.Node) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
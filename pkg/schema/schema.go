@@ -166,6 +166,32 @@ type Stage struct {
 
 	After []Dependency `yaml:"after,omitempty"`
 
+	// Timeout is the maximum time, in seconds, a single attempt of the stage can run.
+	Timeout int `yaml:"timeout,omitempty"`
+	// Retries is the number of times a failed stage is attempted again.
+	Retries int `yaml:"retries,omitempty"`
+	// RetryDelay is the time, in seconds, to wait between attempts.
+	RetryDelay int `yaml:"retry_delay,omitempty"`
+	// OnFailure defines how a failure of the stage affects the rest of the run,
+	// and the commands to run when the stage fails.
+	OnFailure FailureHandler `yaml:"on_failure,omitempty"`
+	// OnSuccess are the commands to run when the stage succeeds.
+	OnSuccess []string `yaml:"on_success,omitempty"`
+	// Finally are the commands to run after the stage, whether it failed or not.
+	Finally []string `yaml:"finally,omitempty"`
+	// RunOnce is a shorthand for `frequency: once`.
+	RunOnce bool `yaml:"run_once,omitempty"`
+	// Frequency defines how often the stage is applied.
+	Frequency Frequency `yaml:"frequency,omitempty"`
+	// Parallel stages are not chained to the previous and next stages, and run
+	// concurrently with them unless ordered with After.
+	Parallel bool `yaml:"parallel,omitempty"`
+	// Transactional stages restore the files they wrote to their previous
+	// content and mode when they fail.
+	Transactional bool `yaml:"transactional,omitempty"`
+	// WaitFor blocks the stage until its conditions are met, before running the plugins.
+	WaitFor WaitFor `yaml:"wait_for,omitempty"`
+
 	DataSources DataSource `yaml:"datasource,omitempty"`
 	Layout      Layout     `yaml:"layout,omitempty"`
 
@@ -187,6 +213,104 @@ const IfCheckAny IfCheckType = "any"
 const IfCheckAll IfCheckType = "all"
 const IfCheckNone IfCheckType = "none"
 
+// WaitFor are the conditions a stage waits for before running. All of them
+// are checked every Interval seconds, until they are met or Timeout seconds pass.
+type WaitFor struct {
+	// File is a path which has to exist
+	File string `yaml:"file,omitempty"`
+	// UnixSocket is the path of a unix socket which has to accept connections
+	UnixSocket string `yaml:"unix_socket,omitempty"`
+	// TCP is an address, in the host:port form, which has to accept connections
+	TCP string `yaml:"tcp,omitempty"`
+	// Command is a command which has to succeed
+	Command string `yaml:"command,omitempty"`
+	// Timeout defaults to 60 seconds
+	Timeout int `yaml:"timeout,omitempty"`
+	// Interval defaults to 1 second
+	Interval int `yaml:"interval,omitempty"`
+}
+
+// IsZero returns true if there is nothing to wait for
+func (w WaitFor) IsZero() bool {
+	return w.File == "" && w.UnixSocket == "" && w.TCP == "" && w.Command == ""
+}
+
+// Frequency defines how often a stage is applied
+type Frequency string
+
+const (
+	// FrequencyAlways applies the stage on every run, this is the default
+	FrequencyAlways Frequency = "always"
+	// FrequencyOnce applies the stage until it succeeds once
+	FrequencyOnce Frequency = "once"
+	// FrequencyPerInstance applies the stage until it succeeds once for every instance id
+	FrequencyPerInstance Frequency = "per-instance"
+)
+
+// GetFrequency returns how often the stage is applied, taking into account run_once
+func (s Stage) GetFrequency() Frequency {
+	switch {
+	case s.Frequency != "":
+		return s.Frequency
+	case s.RunOnce:
+		return FrequencyOnce
+	default:
+		return FrequencyAlways
+	}
+}
+
+// FailurePolicy defines how a stage failure is handled
+type FailurePolicy string
+
+// FailureAbort stops the whole run when the stage fails
+const FailureAbort FailurePolicy = "abort"
+
+// FailureContinue reports the failure and keeps running the next stages (default)
+const FailureContinue FailurePolicy = "continue"
+
+// FailureIgnore logs the failure without reporting it
+const FailureIgnore FailurePolicy = "ignore"
+
+// FailureHandler defines how a stage failure is handled: the policy deciding how the
+// failure affects the rest of the run, and the commands to run after the failure.
+// In yaml it's either a policy, a list of commands or a map with both:
+//
+//	on_failure: abort
+//	on_failure: ["rm -rf /tmp/partial"]
+//	on_failure: {policy: abort, commands: ["rm -rf /tmp/partial"]}
+type FailureHandler struct {
+	Policy   FailurePolicy `yaml:"policy,omitempty"`
+	Commands []string      `yaml:"commands,omitempty"`
+}
+
+func (f *FailureHandler) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&f.Policy)
+	case yaml.SequenceNode:
+		return value.Decode(&f.Commands)
+	default:
+		type plain FailureHandler
+		return value.Decode((*plain)(f))
+	}
+}
+
+func (f FailureHandler) MarshalYAML() (interface{}, error) {
+	type plain FailureHandler
+	switch {
+	case len(f.Commands) == 0:
+		return f.Policy, nil
+	case f.Policy == "":
+		return f.Commands, nil
+	default:
+		return plain(f), nil
+	}
+}
+
+func (f FailureHandler) IsZero() bool {
+	return f.Policy == "" && len(f.Commands) == 0
+}
+
 type UnpackImageConf struct {
 	Source   string `yaml:"source,omitempty"`
 	Target   string `yaml:"target,omitempty"`
@@ -212,6 +336,9 @@ type Packages struct {
 	Remove  []string `yaml:"remove,omitempty"`
 	Refresh bool     `yaml:"refresh,omitempty"`
 	Upgrade bool     `yaml:"upgrade,omitempty"`
+	// RebootIfRequired reboots the system after installing or upgrading packages,
+	// if the package manager flagged that a reboot is required.
+	RebootIfRequired bool `yaml:"reboot_if_required,omitempty"`
 }
 
 type DNS struct {
@@ -222,9 +349,15 @@ type DNS struct {
 }
 
 type YipConfig struct {
-	Source string             `yaml:"-"`
-	Name   string             `yaml:"name,omitempty"`
-	Stages map[string][]Stage `yaml:"stages,omitempty"`
+	Source string `yaml:"-"`
+	// Version is the version of the config format. Configs with an older version,
+	// or without one, are migrated to CurrentVersion when loaded.
+	Version int    `yaml:"version,omitempty"`
+	Name    string `yaml:"name,omitempty"`
+	// Include lists the configs applied before this config: paths, relative to the
+	// config, directories, globs or urls.
+	Include []string           `yaml:"include,omitempty"`
+	Stages  map[string][]Stage `yaml:"stages,omitempty"`
 }
 
 // ToString returns the yaml representation of the YipConfig
@@ -249,32 +382,67 @@ type yipLoader interface {
 	Load(string, []byte, vfs.FS) (*YipConfig, error)
 }
 
-func Load(s string, fs vfs.FS, l Loader, m Modifier) (*YipConfig, error) {
-	if m == nil {
-		m = func(b []byte) ([]byte, error) { return b, nil }
+// LoadOption configures how Load decodes yip configs
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	strict       bool
+	warn         func(source, msg string)
+	skipIncludes bool
+}
+
+// Strict makes Load fail on unknown fields in yip configs, which are ignored otherwise
+func Strict() LoadOption {
+	return func(o *loadOptions) {
+		o.strict = true
 	}
-	if l == nil {
-		l = func(c string, fs vfs.FS, m Modifier) ([]byte, error) { return m([]byte(c)) }
+}
+
+// SkipIncludes makes Load return the config as is, without resolving its includes,
+// e.g. to check whether some content is a yip config.
+func SkipIncludes() LoadOption {
+	return func(o *loadOptions) {
+		o.skipIncludes = true
 	}
-	data, err := l(s, fs, m)
-	if err != nil {
-		return nil, errors.Wrap(err, "while loading yipconfig")
+}
+
+// WarnUnknownFields makes Load call warn with the source and the location of
+// every unknown field in yip configs, which are ignored otherwise.
+func WarnUnknownFields(warn func(source, msg string)) LoadOption {
+	return func(o *loadOptions) {
+		o.warn = warn
 	}
+}
 
-	loader, err := detect(data)
+// Load loads the yip config from s with l, merging the stages of the configs it includes,
+// recursively, before its own. A nil Loader loads s as the content of a config.
+func Load(s string, fs vfs.FS, l Loader, m Modifier, opts ...LoadOption) (*YipConfig, error) {
+	configs, err := NewIncludes(fs, m, opts...).Load(s, l)
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid file type")
+		return nil, err
+	}
+	config := configs[len(configs)-1]
+	if len(configs) == 1 {
+		return config, nil
+	}
+
+	stages := map[string][]Stage{}
+	for _, c := range configs {
+		for name, steps := range c.Stages {
+			stages[name] = append(stages[name], steps...)
+		}
 	}
-	return loader.Load(s, data, fs)
+	config.Stages = stages
+	return config, nil
 }
 
-func detect(b []byte) (yipLoader, error) {
+func detect(b []byte, o loadOptions) (yipLoader, error) {
 	switch {
 	case config.IsCloudConfig(string(b)):
 		return cloudInit{}, nil
 
 	default:
-		return yipYAML{}, nil
+		return yipYAML{options: o}, nil
 	}
 }
 
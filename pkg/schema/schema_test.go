@@ -15,10 +15,14 @@
 package schema_test
 
 import (
+	"encoding/json"
+	"strings"
+
 	. "github.com/mudler/yip/pkg/schema"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/twpayne/go-vfs/v5/vfst"
+	"gopkg.in/yaml.v3"
 )
 
 const fileContent = `
@@ -187,6 +191,157 @@ users:
 			Expect(len(yipConfig.Stages)).To(Equal(3))
 			Expect(yipConfig.Stages["boot"][0].Users["bar"].Name).To(Equal("bar"))
 		})
+
+		It("Reads bootcmd to initramfs stage and runcmd to boot stage", func() {
+			yipConfig := loadstdYip(`#cloud-config
+bootcmd:
+- echo early > /tmp/early
+- [cloud-init-per, once, mymkfs, mkfs, /dev/vdb]
+runcmd:
+- [ls, -l, /]
+- [sh, -xc, "echo $(date) ': hello world!'"]
+- ["echo", "it's"]
+- ls -l /root
+`)
+			Expect(yipConfig.Stages["initramfs"][0].Commands).To(Equal([]string{
+				"echo early > /tmp/early",
+				"cloud-init-per once mymkfs mkfs /dev/vdb",
+			}))
+			Expect(yipConfig.Stages["boot"][0].Commands).To(Equal([]string{
+				"ls -l /",
+				`sh -xc 'echo $(date) '"'"': hello world!'"'"''`,
+				`echo 'it'"'"'s'`,
+				"ls -l /root",
+			}))
+		})
+
+		It("Reads mounts and swap to the first step of boot stage", func() {
+			yipConfig := loadstdYip(`#cloud-config
+mount_default_fields: [~, ~, "auto", "defaults,nofail", "0", "2"]
+mounts:
+- [sdb, /opt/data]
+- ["LABEL=logs", "/var/log/my logs", ext4, "defaults,noatime"]
+- [xvdh, ~]
+swap:
+  filename: /swapfile
+  size: 2G
+runcmd:
+- ls /opt/data
+`)
+			Expect(yipConfig.Stages["boot"]).To(HaveLen(2))
+			Expect(yipConfig.Stages["boot"][0].Commands).To(Equal([]string{
+				"mkdir -p /opt/data",
+				"mkdir -p '/var/log/my logs'",
+				`[ -e /swapfile ] || { size=2147483648; { fallocate -l "$size" /swapfile || dd if=/dev/zero of=/swapfile bs=1M count=$((size / 1048576)); } && chmod 600 /swapfile && mkswap /swapfile; }`,
+				"touch /etc/fstab && sed -i '/comment=cloudconfig/d' /etc/fstab",
+				"printf '%s\\n' '/dev/sdb\t/opt/data\tauto\tdefaults,nofail,comment=cloudconfig\t0\t2' " +
+					"'LABEL=logs\t/var/log/my logs\text4\tdefaults,noatime,comment=cloudconfig\t0\t2' " +
+					"'/swapfile\tnone\tswap\tsw,comment=cloudconfig\t0\t0' >> /etc/fstab",
+				"swapon -a",
+				"mount -a",
+			}))
+			Expect(yipConfig.Stages["boot"][1].Commands).To(Equal([]string{"ls /opt/data"}))
+		})
+
+		It("Sizes the swap after the memory with auto", func() {
+			yipConfig := loadstdYip(`#cloud-config
+swap:
+  size: auto
+  maxsize: 1G
+`)
+			Expect(yipConfig.Stages["boot"][0].Commands[0]).To(HavePrefix("[ -e /swap.img ] || { size=$(awk"))
+			Expect(yipConfig.Stages["boot"][0].Commands[0]).To(ContainSubstring("if (s>1073741824) s=1073741824"))
+			Expect(yipConfig.Stages["boot"][0].Commands).To(ContainElement("swapon -a"))
+		})
+
+		It("Fails on invalid swap sizes", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/yip.yaml": "#cloud-config\nswap:\n  size: lots\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/yip.yaml", fs, FromFile, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid swap size"))
+		})
+
+		It("Reads packages to network stage", func() {
+			yipConfig := loadstdYip(`#cloud-config
+packages:
+- pwgen
+- [libpython2.7, 2.7.3-0ubuntu3.1]
+package_update: true
+package_reboot_if_required: true
+`)
+			Expect(yipConfig.Stages["network"]).To(HaveLen(2))
+			Expect(yipConfig.Stages["network"][1].Packages).To(Equal(Packages{
+				Install:          []string{"pwgen", "libpython2.7=2.7.3-0ubuntu3.1"},
+				Refresh:          true,
+				RebootIfRequired: true,
+			}))
+		})
+
+		It("Reads package_upgrade and the deprecated apt options", func() {
+			yipConfig := loadstdYip(`#cloud-config
+apt_upgrade: true
+`)
+			Expect(yipConfig.Stages["network"]).To(HaveLen(2))
+			Expect(yipConfig.Stages["network"][1].Packages).To(Equal(Packages{Refresh: true, Upgrade: true}))
+
+			yipConfig = loadstdYip(`#cloud-config
+hostname: foo
+`)
+			Expect(yipConfig.Stages["network"]).To(HaveLen(1))
+		})
+
+		It("Fails on invalid packages", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/yip.yaml": "#cloud-config\npackages:\n- [foo, 1, 2]\n"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/yip.yaml", fs, FromFile, nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Reads timezone, locale, keyboard and ntp to initramfs stage", func() {
+			yipConfig := loadstdYip(`#cloud-config
+hostname: foo
+timezone: Europe/Rome
+locale: it_IT.UTF-8
+keyboard:
+  layout: de
+  model: pc105
+  variant: nodeadkeys
+ntp:
+  servers: [ntp.example.com]
+  pools: [0.pool.ntp.org, 1.pool.ntp.org]
+`)
+			initramfs := yipConfig.Stages["initramfs"][0]
+			Expect(initramfs.Hostname).To(Equal("foo"))
+			Expect(initramfs.SystemdFirstBoot).To(Equal(map[string]string{
+				"timezone": "Europe/Rome",
+				"locale":   "it_IT.UTF-8",
+				"keymap":   "de-nodeadkeys",
+				"force":    "true",
+			}))
+			Expect(initramfs.TimeSyncd).To(Equal(map[string]string{"NTP": "ntp.example.com 0.pool.ntp.org 1.pool.ntp.org"}))
+			Expect(initramfs.Systemctl.Enable).To(Equal([]string{"systemd-timesyncd"}))
+		})
+
+		It("Skips ntp when disabled or with other clients", func() {
+			yipConfig := loadstdYip(`#cloud-config
+ntp:
+  enabled: true
+`)
+			Expect(yipConfig.Stages["initramfs"][0].TimeSyncd).To(BeEmpty())
+			Expect(yipConfig.Stages["initramfs"][0].Systemctl.Enable).To(Equal([]string{"systemd-timesyncd"}))
+
+			for _, ntp := range []string{"enabled: false", "ntp_client: chrony"} {
+				yipConfig = loadstdYip("#cloud-config\nntp:\n  " + ntp + "\n  servers: [ntp.example.com]\n")
+				Expect(yipConfig.Stages["initramfs"][0].TimeSyncd).To(BeEmpty())
+				Expect(yipConfig.Stages["initramfs"][0].Systemctl.Enable).To(BeEmpty())
+				Expect(yipConfig.Stages["initramfs"][0].SystemdFirstBoot).To(BeEmpty())
+			}
+		})
 	})
 	Context("YipConfig", Label("schema"), func() {
         // Making sure we bypass this issue:
@@ -215,6 +370,385 @@ users:
 			// Load it back to confirm that dumping it produces a valid yip config
 			_ = loadstdYip(dumped)
 		})
+
+		It("Reads on_failure as a policy, commands or both", func() {
+			yipConfig := loadstdYip(`
+stages:
+  test:
+  - on_failure: abort
+  - on_failure:
+    - echo failed
+  - on_failure:
+      policy: ignore
+      commands:
+      - echo failed
+`)
+			stages := yipConfig.Stages["test"]
+			Expect(stages[0].OnFailure).To(Equal(FailureHandler{Policy: FailureAbort}))
+			Expect(stages[1].OnFailure).To(Equal(FailureHandler{Commands: []string{"echo failed"}}))
+			Expect(stages[2].OnFailure).To(Equal(FailureHandler{Policy: FailureIgnore, Commands: []string{"echo failed"}}))
+
+			dumped := loadstdYip(yipConfig.ToString())
+			Expect(dumped.Stages["test"]).To(Equal(stages))
+		})
+	})
+
+	Context("Including configs", func() {
+		names := func(stages []Stage) []string {
+			res := []string{}
+			for _, s := range stages {
+				res = append(res, s.Name)
+			}
+			return res
+		}
+
+		It("merges the stages of the included files, directories and globs before its own", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/base.yaml": `
+stages:
+  boot:
+  - name: base
+`,
+				"/oem/roles/10-web.yaml": `
+include:
+- ../base.yaml
+stages:
+  boot:
+  - name: web
+`,
+				"/oem/roles/20-db.yml": `
+stages:
+  boot:
+  - name: db
+  network:
+  - name: db-network
+`,
+				"/oem/extra/a.yaml": `
+stages:
+  boot:
+  - name: extra-a
+`,
+				"/oem/extra/b.yaml": `
+stages:
+  boot:
+  - name: extra-b
+`,
+				"/oem/extra/README.md": "not a config",
+				"/oem/node.yaml": `
+include:
+- base.yaml
+- roles
+- /oem/extra/*.yaml
+stages:
+  boot:
+  - name: node
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			yipConfig, err := Load("/oem/node.yaml", fs, FromFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Include).To(BeEmpty())
+			Expect(names(yipConfig.Stages["boot"])).To(Equal([]string{"base", "web", "db", "extra-a", "extra-b", "node"}))
+			Expect(names(yipConfig.Stages["network"])).To(Equal([]string{"db-network"}))
+		})
+
+		It("loads configs included by several ones only once", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/00-base.yaml": "stages: {boot: [{name: base}]}\n",
+				"/oem/10-web.yaml":  "include: [00-base.yaml]\nstages: {boot: [{name: web}]}\n",
+				"/oem/20-db.yaml":   "include: [00-base.yaml]\nstages: {boot: [{name: db}]}\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			inc := NewIncludes(fs, nil)
+			sources := func(configs []*YipConfig) []string {
+				res := []string{}
+				for _, c := range configs {
+					res = append(res, c.Source)
+				}
+				return res
+			}
+
+			configs, err := inc.Load("/oem/10-web.yaml", FromFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sources(configs)).To(Equal([]string{"/oem/00-base.yaml", "/oem/10-web.yaml"}))
+			Expect(names(configs[0].Stages["boot"])).To(Equal([]string{"base"}))
+			Expect(names(configs[1].Stages["boot"])).To(Equal([]string{"web"}))
+
+			configs, err = inc.Load("/oem/20-db.yaml", FromFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sources(configs)).To(Equal([]string{"/oem/20-db.yaml"}))
+
+			configs, err = inc.Load("/oem/00-base.yaml", FromFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(configs).To(BeEmpty())
+		})
+
+		It("doesn't resolve includes when skipping them", func() {
+			yipConfig, err := Load("include: [missing.yaml]\nstages: {boot: [{name: own}]}\n", nil, nil, nil, SkipIncludes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Include).To(Equal([]string{"missing.yaml"}))
+			Expect(names(yipConfig.Stages["boot"])).To(Equal([]string{"own"}))
+		})
+
+		It("detects include cycles", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/a.yaml": "include: [b.yaml]\n",
+				"/oem/b.yaml": "include: [c.yaml]\n",
+				"/oem/c.yaml": "include: [a.yaml]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/oem/a.yaml", fs, FromFile, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("include cycle: /oem/a.yaml -> /oem/b.yaml -> /oem/c.yaml -> /oem/a.yaml"))
+		})
+
+		It("fails on missing includes", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/a.yaml": "include: [missing.yaml]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/oem/a.yaml", fs, FromFile, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Migrating configs", func() {
+		const unversioned = `# Unversioned config
+name: unversioned
+stages:
+  boot:
+  - name: first
+    files:
+    - path: /tmp/foo
+      permissions: 0600
+`
+		It("loads configs without version", func() {
+			yipConfig, err := Load(unversioned, nil, nil, nil, Strict())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Version).To(Equal(0))
+			Expect(yipConfig.Stages["boot"][0].Files[0].Permissions).To(Equal(uint32(0600)))
+		})
+
+		It("loads configs with the current version", func() {
+			yipConfig, err := Load("version: 1\nstages:\n  boot:\n  - after: [{name: first}]\n", nil, nil, nil, Strict())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Version).To(Equal(CurrentVersion))
+			Expect(yipConfig.Stages["boot"][0].After).To(Equal([]Dependency{{Name: "first"}}))
+		})
+
+		It("fails on unsupported versions", func() {
+			_, err := Load("version: 100\n", nil, nil, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unsupported version 100"))
+		})
+
+		It("leaves configs which need no migration untouched", func() {
+			migrated, changed, err := Migrate([]byte(unversioned))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+			Expect(string(migrated)).To(Equal(unversioned))
+		})
+
+		It("fails migrating configs with unsupported versions", func() {
+			_, _, err := Migrate([]byte("version: 100\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("leaves cloud-config files untouched", func() {
+			_, changed, err := Migrate([]byte("#cloud-config\nhostname: foo\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+
+		Context("with a migration to a newer version", func() {
+			const titled = `# Config of the previous version
+title: titled
+stages:
+  boot:
+  - name: first
+    files:
+    - path: /tmp/foo
+      permissions: 0600
+`
+			BeforeEach(func() {
+				// The newer version renames the top level title to name
+				DeferCleanup(AddMigration(func(root *yaml.Node) bool {
+					for i := 0; i+1 < len(root.Content); i += 2 {
+						if root.Content[i].Value == "title" {
+							root.Content[i].Value = "name"
+							return true
+						}
+					}
+					return false
+				}))
+			})
+
+			It("migrates configs when loading them", func() {
+				yipConfig, err := Load(titled, nil, nil, nil, Strict())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(yipConfig.Name).To(Equal("titled"))
+				Expect(yipConfig.Version).To(Equal(CurrentVersion + 1))
+				Expect(yipConfig.Stages["boot"][0].Files[0].Permissions).To(Equal(uint32(0600)))
+			})
+
+			It("doesn't migrate configs with the newer version", func() {
+				_, err := Load("version: 2\ntitle: titled\n", nil, nil, nil, Strict())
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("line 2: field title not found"))
+			})
+
+			It("rewrites migrated configs with the new version, keeping comments", func() {
+				migrated, changed, err := Migrate([]byte(titled))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(changed).To(BeTrue())
+				Expect(string(migrated)).To(HavePrefix("# Config of the previous version\nversion: 2\nname: titled\n"))
+				Expect(string(migrated)).To(ContainSubstring("permissions: 0600\n"))
+
+				_, changed, err = Migrate(migrated)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(changed).To(BeFalse())
+			})
+
+			It("reports errors of migrated configs at their position in the original file", func() {
+				_, err := Load(titled+"    authorised_keys: {}\n", nil, nil, nil, Strict())
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("line 9: field authorised_keys not found"))
+
+				_, err = Load(strings.Replace(titled, "0600", "rw", 1), nil, nil, nil)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("line 8: cannot unmarshal"))
+			})
+		})
+	})
+
+	Context("Strict mode", func() {
+		const typo = `stages:
+  boot:
+  - name: typo
+    authorised_keys:
+      root: [github:mudler]
+    commands: [echo typo]
+`
+		It("ignores unknown fields by default", func() {
+			yipConfig, err := Load(typo, nil, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Stages["boot"][0].Commands).To(Equal([]string{"echo typo"}))
+		})
+
+		It("fails on unknown fields", func() {
+			_, err := Load(typo, nil, nil, nil, Strict())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("line 4: field authorised_keys not found"))
+		})
+
+		It("warns about unknown fields", func() {
+			warnings := []string{}
+			yipConfig, err := Load(typo, nil, nil, nil, WarnUnknownFields(func(source, msg string) {
+				warnings = append(warnings, msg)
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(yipConfig.Stages["boot"][0].Commands).To(Equal([]string{"echo typo"}))
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("line 4: field authorised_keys not found"))
+		})
+
+		It("reports the position of unknown fields in the original file", func() {
+			_, err := Load("# Config\nversion: 1\n"+typo, nil, nil, nil, Strict())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("line 6: field authorised_keys not found in stages.boot[0]"))
+		})
+
+		It("still fails on wrong types when warning", func() {
+			_, err := Load("stages:\n  boot:\n  - timeout: foo\n    typo: true\n", nil, nil, nil, WarnUnknownFields(func(string, string) {}))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).ToNot(ContainSubstring("typo"))
+		})
+
+		It("accepts empty configs", func() {
+			_, err := Load("", nil, nil, nil, Strict())
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("Validating configs", func() {
+		It("generates the JSON Schema from the config types", func() {
+			s := YipJSONSchema()
+			stage := s.Properties["stages"].AdditionalProperties.(*JSONSchema).Items
+			Expect(stage.AdditionalProperties).To(Equal(false))
+			Expect(stage.Properties["sysctl"].Type).To(Equal("object"))
+			Expect(stage.Properties["files"].Items.Properties["permissions"].Type).To(Equal("integer"))
+			Expect(stage.Properties["on_failure"].AnyOf).To(HaveLen(3))
+			_, err := json.Marshal(s)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts valid configs", func() {
+			errs, err := Validate([]byte(`name: "test"
+include: [base.yaml]
+stages:
+  boot:
+  - name: base
+    files:
+    - path: /tmp/foo
+      permissions: 0644
+      content: foo
+    sysctl:
+      debug.exception-trace: "0"
+    if_files:
+      any: [/etc/foo]
+    on_failure: abort
+  - on_failure: ["rm -rf /tmp/foo"]
+    frequency: once
+  - on_failure: {policy: continue, commands: [true]}
+    after:
+    - name: base
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(BeEmpty())
+		})
+
+		It("reports the problems with their position", func() {
+			errs, err := Validate([]byte(`stages:
+  boot:
+  - name: typo
+    systctl:
+      debug.exception-trace: "0"
+    files:
+    - path: /tmp/foo
+      permissions: "rw-r--r--"
+    frequency: daily
+  - on_failure: {policy: abort, command: []}
+    if_files:
+      some: [/etc/foo]
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(Equal([]ValidationError{
+				{Line: 4, Column: 5, Message: `stages.boot[0]: unknown field "systctl", did you mean "sysctl"?`},
+				{Line: 8, Column: 20, Message: `stages.boot[0].files[0].permissions: expected integer, got string`},
+				{Line: 9, Column: 16, Message: `stages.boot[0].frequency: "daily" is not one of always, once, per-instance`},
+				{Line: 10, Column: 33, Message: `stages.boot[1].on_failure: unknown field "command", did you mean "commands"?`},
+				{Line: 12, Column: 7, Message: `stages.boot[1].if_files: "some" is not one of any, all, none`},
+			}))
+		})
+
+		It("reports malformed yaml", func() {
+			_, err := Validate([]byte("stages:\n  boot: [\n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("skips cloud-config files", func() {
+			errs, err := Validate([]byte("#cloud-config\nfoo: bar\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(BeEmpty())
+		})
 	})
 
 })
@@ -0,0 +1,273 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	config "github.com/mudler/yip/pkg/schema/cloudinit"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem found in a yip config, at the given line and column
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// validationError is a ValidationError with the details of unknown fields
+type validationError struct {
+	ValidationError
+	// unknownField is the name of the field, if the error is about an unknown field
+	unknownField string
+	// path is the path of the mapping holding the unknown field
+	path string
+}
+
+// Validate checks a yip config against the JSON Schema of YipConfig, returning
+// the problems found sorted by position. The error is set if the yaml is malformed.
+// Cloud-config content is converted rather than loaded as is, so it is not validated.
+func Validate(b []byte) ([]ValidationError, error) {
+	if config.IsCloudConfig(string(b)) {
+		return nil, nil
+	}
+	doc, err := parseDocument(b)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	// Older configs are valid as long as they can be migrated, the nodes
+	// which are not changed by the migrations keep their position.
+	if _, err := migrate(doc); err != nil {
+		return nil, err
+	}
+	res := []ValidationError{}
+	for _, e := range validateNode(doc, YipJSONSchema(), "") {
+		res = append(res, e.ValidationError)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Line != res[j].Line {
+			return res[i].Line < res[j].Line
+		}
+		return res[i].Column < res[j].Column
+	})
+	return res, nil
+}
+
+// unknownFields returns the fields of the yip config document which are not
+// part of YipConfig, with their position
+func unknownFields(doc *yaml.Node) []validationError {
+	res := []validationError{}
+	for _, e := range validateNode(doc, YipJSONSchema(), "") {
+		if e.unknownField != "" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func validateNode(n *yaml.Node, s *JSONSchema, path string) []validationError {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return validateNode(n.Content[0], s, path)
+	case yaml.AliasNode:
+		return validateNode(n.Alias, s, path)
+	}
+	// Null values decode to the zero value of any field
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+
+	if len(s.AnyOf) > 0 {
+		return validateAnyOf(n, s, path)
+	}
+
+	if s.Type != "" && !matchesType(n, s.Type) {
+		return []validationError{nodeError(n, "%sexpected %s, got %s", prefix(path), s.Type, nodeType(n))}
+	}
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if len(s.Enum) > 0 && !contains(s.Enum, n.Value) {
+			return []validationError{nodeError(n, "%s%q is not one of %s", prefix(path), n.Value, strings.Join(s.Enum, ", "))}
+		}
+	case yaml.SequenceNode:
+		if s.Items == nil {
+			return nil
+		}
+		res := []validationError{}
+		for i, item := range n.Content {
+			res = append(res, validateNode(item, s.Items, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return res
+	case yaml.MappingNode:
+		return validateMapping(n, s, path)
+	}
+	return nil
+}
+
+func validateMapping(n *yaml.Node, s *JSONSchema, path string) []validationError {
+	res := []validationError{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		// Merge keys bring in the keys of the anchored mappings
+		if key.Tag == "!!merge" {
+			res = append(res, validateNode(value, s, path)...)
+			continue
+		}
+		child := key.Value
+		if path != "" {
+			child = path + "." + key.Value
+		}
+
+		if s.PropertyNames != nil && len(s.PropertyNames.Enum) > 0 && !contains(s.PropertyNames.Enum, key.Value) {
+			res = append(res, nodeError(key, "%s%q is not one of %s", prefix(path), key.Value, strings.Join(s.PropertyNames.Enum, ", ")))
+			continue
+		}
+
+		if prop, ok := s.Properties[key.Value]; ok {
+			res = append(res, validateNode(value, prop, child)...)
+			continue
+		}
+		switch additional := s.AdditionalProperties.(type) {
+		case *JSONSchema:
+			res = append(res, validateNode(value, additional, child)...)
+		case bool:
+			if !additional {
+				msg := fmt.Sprintf("%sunknown field %q", prefix(path), key.Value)
+				if suggestion := closest(key.Value, s.Properties); suggestion != "" {
+					msg += fmt.Sprintf(", did you mean %q?", suggestion)
+				}
+				e := nodeError(key, "%s", msg)
+				e.unknownField, e.path = key.Value, path
+				res = append(res, e)
+			}
+		}
+	}
+	return res
+}
+
+// validateAnyOf validates the node against the alternatives matching its type
+func validateAnyOf(n *yaml.Node, s *JSONSchema, path string) []validationError {
+	var res []validationError
+	types := []string{}
+	for _, alt := range s.AnyOf {
+		types = append(types, alt.Type)
+		if alt.Type != "" && !matchesType(n, alt.Type) {
+			continue
+		}
+		errs := validateNode(n, alt, path)
+		if len(errs) == 0 {
+			return nil
+		}
+		if res == nil {
+			res = errs
+		}
+	}
+	if res == nil {
+		return []validationError{nodeError(n, "%sexpected %s, got %s", prefix(path), strings.Join(types, " or "), nodeType(n))}
+	}
+	return res
+}
+
+func matchesType(n *yaml.Node, t string) bool {
+	switch t {
+	case "object":
+		return n.Kind == yaml.MappingNode
+	case "array":
+		return n.Kind == yaml.SequenceNode
+	case "string":
+		// Any scalar decodes into a string
+		return n.Kind == yaml.ScalarNode
+	case "integer":
+		return n.Kind == yaml.ScalarNode && n.Tag == "!!int"
+	case "number":
+		return n.Kind == yaml.ScalarNode && (n.Tag == "!!int" || n.Tag == "!!float")
+	case "boolean":
+		return n.Kind == yaml.ScalarNode && n.Tag == "!!bool"
+	}
+	return true
+}
+
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.Tag {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+func nodeError(n *yaml.Node, format string, args ...interface{}) validationError {
+	return validationError{ValidationError: ValidationError{Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, args...)}}
+}
+
+func prefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the property with the smallest edit distance to the given
+// key, if it's close enough to be a typo
+func closest(key string, props map[string]*JSONSchema) string {
+	names := []string{}
+	for p := range props {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	best, bestDistance := "", 3
+	for _, p := range names {
+		if d := levenshtein(key, p); d < bestDistance {
+			best, bestDistance = p, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
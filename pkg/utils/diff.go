@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+)
+
+// Diff returns a line based diff between a and b.
+// Removed lines are prefixed by "-", added lines by "+". Lines which are
+// common to both inputs are omitted. An empty string is returned when a and b
+// are equal.
+func Diff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	from := splitLines(a)
+	to := splitLines(b)
+
+	// Longest common subsequence table, lcs[i][j] is the LCS length of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out.WriteString("-" + from[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + to[j] + "\n")
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		out.WriteString("-" + from[i] + "\n")
+	}
+	for ; j < len(to); j++ {
+		out.WriteString("+" + to[j] + "\n")
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
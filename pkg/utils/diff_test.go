@@ -0,0 +1,21 @@
+package utils_test
+
+import (
+	. "github.com/mudler/yip/pkg/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diff", func() {
+	It("returns nothing for equal inputs", func() {
+		Expect(Diff("foo\nbar\n", "foo\nbar\n")).To(Equal(""))
+	})
+	It("reports added lines", func() {
+		Expect(Diff("", "foo\nbar\n")).To(Equal("+foo\n+bar\n"))
+	})
+	It("reports removed and changed lines", func() {
+		Expect(Diff("foo\nbar\nbaz\n", "foo\nqux\nbaz\n")).To(Equal("-bar\n+qux\n"))
+		Expect(Diff("foo\nbar\n", "bar\n")).To(Equal("-foo\n"))
+	})
+})
@@ -0,0 +1,66 @@
+//   Copyright 2020 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package watch reports the yip files changed in a set of directories
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Watch watches the given directories, and their subdirectories, calling onChange with
+// the yip files (.yaml and .yml) written or moved into them.
+// Changes are debounced: onChange is called once no file changed for the debounce time,
+// with all the files changed meanwhile in lexicographic order.
+// Watch blocks until the context is cancelled, or watching fails.
+func Watch(ctx context.Context, debounce time.Duration, onChange func([]string), dirs ...string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- watch(ctx, dirs, events)
+	}()
+
+	changed := map[string]bool{}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case path := <-events:
+			changed[path] = true
+			timer.Reset(debounce)
+		case <-timer.C:
+			files := []string{}
+			for f := range changed {
+				files = append(files, f)
+			}
+			sort.Strings(files)
+			changed = map[string]bool{}
+			onChange(files)
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+func isYipFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
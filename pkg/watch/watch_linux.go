@@ -0,0 +1,134 @@
+//go:build linux
+
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fileEvents are the events of files which are reported as changed
+	fileEvents = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+	// watchMask also includes the creation of directories, which are watched too
+	watchMask = fileEvents | unix.IN_CREATE
+)
+
+type inotify struct {
+	fd   int
+	dirs map[int]string
+}
+
+// watch sends the changed yip files in dirs to events, using inotify
+func watch(ctx context.Context, dirs []string, events chan<- string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking file is handled by the runtime poller, so closing it interrupts Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	w := &inotify{fd: fd, dirs: map[int]string{}}
+	for _, d := range dirs {
+		if _, err := w.addTree(d); err != nil {
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.Close()
+		case <-stop:
+		}
+	}()
+
+	send := func(path string) bool {
+		select {
+		case events <- path:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(ev.Len)]
+			offset += unix.SizeofInotifyEvent + int(ev.Len)
+
+			dir, ok := w.dirs[int(ev.Wd)]
+			if !ok {
+				continue
+			}
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, int(ev.Wd))
+				continue
+			}
+			path := filepath.Join(dir, strings.TrimRight(string(name), "\x00"))
+
+			if ev.Mask&unix.IN_ISDIR != 0 {
+				if ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) == 0 {
+					continue
+				}
+				// Files can be written in the new directory before it's watched
+				files, err := w.addTree(path)
+				if err != nil {
+					return err
+				}
+				for _, f := range files {
+					if !send(f) {
+						return nil
+					}
+				}
+				continue
+			}
+			if ev.Mask&fileEvents != 0 && isYipFile(path) {
+				if !send(path) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// addTree watches root and its subdirectories, returning the yip files in them
+func (w *inotify) addTree(root string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if isYipFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch "+path, err)
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package watch_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/mudler/yip/pkg/watch"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watch", func() {
+	It("reports the changed yip files, debounced", func() {
+		dir, err := os.MkdirTemp("", "yip-watch")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(os.WriteFile(filepath.Join(dir, "existing.yaml"), []byte{}, 0644)).To(Succeed())
+
+		var lock sync.Mutex
+		calls := [][]string{}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- Watch(ctx, 200*time.Millisecond, func(files []string) {
+				lock.Lock()
+				defer lock.Unlock()
+				calls = append(calls, files)
+			}, dir)
+		}()
+		// Give inotify the time to set up the watches
+		time.Sleep(100 * time.Millisecond)
+
+		Expect(os.WriteFile(filepath.Join(dir, "b.yml"), []byte("stages: {}"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("stages: {}"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("foo"), 0644)).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(dir, "sub"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "sub", "c.yaml"), []byte("stages: {}"), 0644)).To(Succeed())
+
+		Eventually(func() [][]string {
+			lock.Lock()
+			defer lock.Unlock()
+			return calls
+		}, 5*time.Second).Should(Equal([][]string{{
+			filepath.Join(dir, "a.yaml"),
+			filepath.Join(dir, "b.yml"),
+			filepath.Join(dir, "sub", "c.yaml"),
+		}}))
+
+		cancel()
+		Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+	})
+
+	It("fails on missing directories", func() {
+		err := Watch(context.Background(), time.Second, func([]string) {}, "/does/not/exist")
+		Expect(err).To(HaveOccurred())
+	})
+})
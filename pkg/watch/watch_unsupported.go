@@ -0,0 +1,12 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+	"errors"
+)
+
+func watch(ctx context.Context, dirs []string, events chan<- string) error {
+	return errors.New("watching directories is supported only on linux")
+}